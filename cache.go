@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"subs-check-custom/types"
+)
+
+const resultsBucket = "results"
+
+// cachedResult is the last tested state of a node
+type cachedResult struct {
+	Time   time.Time   `json:"t"`
+	Stages string      `json:"stages"` // stagesKey of the pipeline that tested the node
+	Kept   bool        `json:"kept"`   // Whether the node made it into the test output
+	Node   types.Proxy `json:"node"`
+}
+
+// stagesKey identifies a pipeline, so results are only reused by the same tests
+func stagesKey(stages []types.TestStage) string {
+	data, _ := json.Marshal(stages)
+	return string(data)
+}
+
+// splitCached separates nodes tested by the same stages within cfg.CacheTTL
+// minutes from those that need testing. Cached nodes that made it into the last
+// output are returned with their stored results under the current name. Nodes
+// the last run dropped are tested again rather than left out unseen.
+func splitCached(cfg types.Config, nodes []types.Proxy, stages []types.TestStage, testLogger *log.Logger) (fresh, cached []types.Proxy, hits int) {
+	if cfg.CacheTTL <= 0 {
+		return nodes, nil, 0
+	}
+	if cfg.HistoryDB == "" {
+		log.Printf("cache-ttl is %d but history-db is empty, so every node is tested", cfg.CacheTTL)
+		return nodes, nil, 0
+	}
+	if _, err := os.Stat(cfg.HistoryDB); err != nil {
+		return nodes, nil, 0 // Nothing has been tested yet
+	}
+	db, err := bolt.Open(cfg.HistoryDB, 0644, &bolt.Options{Timeout: time.Second, ReadOnly: true})
+	if err != nil {
+		testLogger.Printf("Cache - Failed to open %s: %v", cfg.HistoryDB, err)
+		return nodes, nil, 0
+	}
+	defer db.Close()
+
+	cutoff := time.Now().Add(-time.Duration(cfg.CacheTTL) * time.Minute)
+	key := stagesKey(stages)
+	retests := 0
+	db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(resultsBucket))
+		for _, node := range nodes {
+			var result cachedResult
+			if bucket != nil {
+				if data := bucket.Get([]byte(node.Fingerprint())); data != nil && json.Unmarshal(data, &result) == nil && result.Time.After(cutoff) && result.Stages == key {
+					if result.Kept {
+						hits++
+						result.Node.Name = node.Name
+						result.Node.Source = node.Source
+						cached = append(cached, result.Node)
+						continue
+					}
+					retests++
+				}
+			}
+			fresh = append(fresh, node)
+		}
+		return nil
+	})
+	testLogger.Printf("Cache - Reusing results of %d nodes tested in the last %d minutes, %d to test (%d dropped last time)", hits, cfg.CacheTTL, len(fresh), retests)
+	return fresh, cached, hits
+}
+
+// storeResults saves the final state of every node tested in this run for splitCached
+func storeResults(cfg types.Config, stages []types.TestStage, candidates, tested []types.Proxy, outcomes map[string]*types.TestFailure, testLogger *log.Logger) {
+	if cfg.CacheTTL <= 0 || cfg.HistoryDB == "" {
+		return
+	}
+	db, err := bolt.Open(cfg.HistoryDB, 0644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		testLogger.Printf("Cache - Failed to open %s: %v", cfg.HistoryDB, err)
+		return
+	}
+	defer db.Close()
+
+	now := time.Now()
+	pipeline := stagesKey(stages)
+	testedByKey := make(map[string]types.Proxy, len(tested))
+	for _, node := range tested {
+		testedByKey[node.Fingerprint()] = node
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(resultsBucket))
+		if err != nil {
+			return err
+		}
+		for _, node := range candidates {
+			key := node.Fingerprint()
+			failure, ok := outcomes[key]
+			if !ok {
+				continue // Skipped by every stage, so there is nothing to reuse
+			}
+			result := cachedResult{Time: now, Stages: pipeline, Node: node}
+			if kept, ok := testedByKey[key]; ok {
+				result.Kept = true
+				result.Node = kept
+			} else {
+				result.Node.Failure = failure
+			}
+			data, err := json.Marshal(result)
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put([]byte(key), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		testLogger.Printf("Cache - Failed to store results in %s: %v", cfg.HistoryDB, err)
+	}
+}
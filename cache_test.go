@@ -0,0 +1,47 @@
+package main
+
+import (
+	"io"
+	"log"
+	"path/filepath"
+	"testing"
+
+	"subs-check-custom/types"
+)
+
+func TestCacheRoundTrip(t *testing.T) {
+	cfg := types.Config{CacheTTL: 10, HistoryDB: filepath.Join(t.TempDir(), "history.db")}
+	logger := log.New(io.Discard, "", 0)
+	stages := []types.TestStage{{Stage: StageTCP}, {Stage: StageDownload, MinSpeed: 500}}
+
+	nodes := testNodesFor(3)
+	kept := nodes[0]
+	kept.Speed = 2048
+	outcomes := map[string]*types.TestFailure{
+		nodes[0].Fingerprint(): nil,
+		nodes[1].Fingerprint(): {Stage: "Speed test", Class: FailureThreshold, Error: "too slow"},
+		// nodes[2] was skipped by every stage, so it has no outcome
+	}
+	storeResults(cfg, stages, nodes, []types.Proxy{kept}, outcomes, logger)
+
+	renamed := append([]types.Proxy(nil), nodes...)
+	renamed[0].Name = "renamed"
+	fresh, cached, hits := splitCached(cfg, renamed, stages, logger)
+	if hits != 1 || len(cached) != 1 || cached[0].Name != "renamed" || cached[0].Speed != 2048 {
+		t.Fatalf("cached = %+v (hits %d), want node 0 with its speed under the new name", cached, hits)
+	}
+	if len(fresh) != 2 || fresh[0].Name != nodes[1].Name || fresh[1].Name != nodes[2].Name {
+		t.Errorf("fresh = %v, want the dropped and the untested node", fresh)
+	}
+
+	other := []types.TestStage{{Stage: StageTCP}, {Stage: StageDownload, MinSpeed: 1000}}
+	fresh, cached, hits = splitCached(cfg, nodes, other, logger)
+	if hits != 0 || len(cached) != 0 || len(fresh) != len(nodes) {
+		t.Errorf("with other stages got %d fresh, %d cached, want every node retested", len(fresh), len(cached))
+	}
+
+	cfg.HistoryDB = ""
+	if fresh, _, _ := splitCached(cfg, nodes, stages, logger); len(fresh) != len(nodes) {
+		t.Errorf("without a history db got %d fresh nodes, want %d", len(fresh), len(nodes))
+	}
+}
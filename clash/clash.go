@@ -0,0 +1,180 @@
+// Package clash turns parsed proxies into Clash/mihomo proxy entries
+package clash
+
+import (
+	"fmt"
+	"strings"
+
+	"subs-check-custom/types"
+)
+
+// Proxy is one entry of a mihomo proxies: list. Only the keys valid for the
+// entry's type are set; everything else is left empty and omitted.
+type Proxy struct {
+	Name   string `yaml:"name" json:"name"`
+	Type   string `yaml:"type" json:"type"`
+	Server string `yaml:"server,omitempty" json:"server,omitempty"`
+	Port   int    `yaml:"port,omitempty" json:"port,omitempty"`
+
+	Cipher        string `yaml:"cipher,omitempty" json:"cipher,omitempty"`                 // ss, ssr, vmess
+	Password      string `yaml:"password,omitempty" json:"password,omitempty"`             // ss, ssr, trojan, hysteria2
+	UUID          string `yaml:"uuid,omitempty" json:"uuid,omitempty"`                     // vmess, vless
+	AlterID       *int   `yaml:"alterId,omitempty" json:"alterId,omitempty"`               // vmess
+	Flow          string `yaml:"flow,omitempty" json:"flow,omitempty"`                     // vless
+	Protocol      string `yaml:"protocol,omitempty" json:"protocol,omitempty"`             // ssr
+	ProtocolParam string `yaml:"protocol-param,omitempty" json:"protocol-param,omitempty"` // ssr
+	Obfs          string `yaml:"obfs,omitempty" json:"obfs,omitempty"`                     // ssr, hysteria2
+	ObfsParam     string `yaml:"obfs-param,omitempty" json:"obfs-param,omitempty"`         // ssr
+	ObfsPassword  string `yaml:"obfs-password,omitempty" json:"obfs-password,omitempty"`   // hysteria2
+	UDP           *bool  `yaml:"udp,omitempty" json:"udp,omitempty"`                       // UDP test result, nil when untested
+
+	TLS               bool         `yaml:"tls,omitempty" json:"tls,omitempty"`                               // vmess, vless
+	ServerName        string       `yaml:"servername,omitempty" json:"servername,omitempty"`                 // vmess, vless
+	SNI               string       `yaml:"sni,omitempty" json:"sni,omitempty"`                               // trojan, hysteria2
+	SkipCertVerify    bool         `yaml:"skip-cert-verify,omitempty" json:"skip-cert-verify,omitempty"`     // TLS types
+	ALPN              []string     `yaml:"alpn,omitempty" json:"alpn,omitempty"`                             // TLS types
+	ClientFingerprint string       `yaml:"client-fingerprint,omitempty" json:"client-fingerprint,omitempty"` // vmess, vless, trojan
+	RealityOpts       *RealityOpts `yaml:"reality-opts,omitempty" json:"reality-opts,omitempty"`             // vless, trojan
+
+	Network  string    `yaml:"network,omitempty" json:"network,omitempty"` // ws or grpc, omitted for tcp
+	WSOpts   *WSOpts   `yaml:"ws-opts,omitempty" json:"ws-opts,omitempty"`
+	GrpcOpts *GrpcOpts `yaml:"grpc-opts,omitempty" json:"grpc-opts,omitempty"`
+}
+
+// WSOpts configures the ws transport, or HTTPUpgrade with V2rayHTTPUpgrade
+type WSOpts struct {
+	Path             string            `yaml:"path,omitempty" json:"path,omitempty"`
+	Headers          map[string]string `yaml:"headers,omitempty" json:"headers,omitempty"`
+	V2rayHTTPUpgrade bool              `yaml:"v2ray-http-upgrade,omitempty" json:"v2ray-http-upgrade,omitempty"`
+}
+
+// GrpcOpts configures the grpc transport
+type GrpcOpts struct {
+	ServiceName string `yaml:"grpc-service-name" json:"grpc-service-name"`
+}
+
+// RealityOpts configures REALITY in place of TLS
+type RealityOpts struct {
+	PublicKey string `yaml:"public-key" json:"public-key"`
+	ShortID   string `yaml:"short-id,omitempty" json:"short-id,omitempty"`
+}
+
+// Convert returns the mihomo form of node, or an error if mihomo cannot carry it
+func Convert(node types.Proxy) (Proxy, error) {
+	p := Proxy{
+		Name:   node.Name,
+		Type:   node.Type,
+		Server: node.Server,
+		Port:   node.Port,
+		UDP:    node.UDP,
+	}
+
+	switch node.Type {
+	case "ss":
+		p.Cipher = node.Cipher
+		p.Password = node.Password
+		return p, nil
+	case "ssr":
+		p.Cipher = node.Cipher
+		p.Password = node.Password
+		p.Protocol = node.Protocol
+		p.ProtocolParam = node.ProtocolParam
+		p.Obfs = node.Obfs
+		p.ObfsParam = node.ObfsParam
+		return p, nil
+	case "hysteria2":
+		p.Password = node.Password
+		p.Obfs = node.Obfs
+		p.ObfsPassword = node.ObfsPassword
+		p.SNI = node.SNI
+		p.SkipCertVerify = node.SkipCertVerify
+		p.ALPN = node.ALPN
+		return p, nil
+	case "vmess":
+		cipher := node.Cipher
+		if cipher == "" {
+			cipher = "auto"
+		}
+		alterID := node.AlterID
+		p.UUID = node.UUID
+		p.AlterID = &alterID
+		p.Cipher = cipher
+		if node.TLS {
+			p.TLS = true
+			p.ServerName = node.SNI
+		}
+	case "vless":
+		p.UUID = node.UUID
+		p.Flow = node.Flow
+		if node.TLS {
+			p.TLS = true
+			p.ServerName = node.SNI
+		}
+	case "trojan":
+		p.Password = node.Password
+		p.SNI = node.SNI
+	default:
+		return Proxy{}, fmt.Errorf("%s nodes are not supported by mihomo", node.Type)
+	}
+
+	// vmess, vless and trojan share TLS and transport options
+	if node.TLS || node.Type == "trojan" {
+		p.SkipCertVerify = node.SkipCertVerify
+		p.ALPN = node.ALPN
+		p.ClientFingerprint = node.ClientFingerprint
+		if key := node.RealityOpts["public-key"]; key != "" && node.Type != "vmess" {
+			p.RealityOpts = &RealityOpts{PublicKey: key, ShortID: node.RealityOpts["short-id"]}
+			if p.ClientFingerprint == "" {
+				p.ClientFingerprint = "chrome" // REALITY requires a uTLS fingerprint
+			}
+		}
+	}
+	if err := setTransport(&p, node); err != nil {
+		return Proxy{}, err
+	}
+	return p, nil
+}
+
+// setTransport fills the network and its options
+func setTransport(p *Proxy, node types.Proxy) error {
+	path := node.WSOpts["path"]
+	if path == "" {
+		path = node.Path
+	}
+	var headers map[string]string
+	if host := node.WSOpts["host"]; host != "" {
+		headers = map[string]string{"Host": host}
+	}
+
+	switch n := strings.ToLower(node.Network); n {
+	case "", "tcp", "raw":
+	case "ws", "websocket":
+		p.Network = "ws"
+		p.WSOpts = &WSOpts{Path: path, Headers: headers}
+	case "httpupgrade":
+		p.Network = "ws"
+		p.WSOpts = &WSOpts{Path: path, Headers: headers, V2rayHTTPUpgrade: true}
+	case "grpc":
+		p.Network = "grpc"
+		p.GrpcOpts = &GrpcOpts{ServiceName: node.GrpcOpts["grpc-service-name"]}
+	default:
+		return fmt.Errorf("%s transport is not supported by mihomo", n)
+	}
+	return nil
+}
+
+// ConvertAll converts every node mihomo can carry and reports the rest through skipped
+func ConvertAll(nodes []types.Proxy, skipped func(node types.Proxy, err error)) []Proxy {
+	proxies := make([]Proxy, 0, len(nodes))
+	for _, node := range nodes {
+		p, err := Convert(node)
+		if err != nil {
+			if skipped != nil {
+				skipped(node, err)
+			}
+			continue
+		}
+		proxies = append(proxies, p)
+	}
+	return proxies
+}
@@ -0,0 +1,174 @@
+package clash
+
+import (
+	"testing"
+
+	"gopkg.in/yaml.v3"
+
+	"subs-check-custom/types"
+)
+
+// Keys mihomo accepts on every proxy
+var commonKeys = []string{"name", "type", "server", "port", "udp", "ip-version", "interface-name", "routing-mark", "tfo", "mptcp", "dialer-proxy", "smux"}
+
+// Keys mihomo accepts per proxy type, besides commonKeys
+var mihomoKeys = map[string][]string{
+	"ss":  {"cipher", "password", "plugin", "plugin-opts", "udp-over-tcp", "udp-over-tcp-version", "client-fingerprint"},
+	"ssr": {"cipher", "password", "obfs", "obfs-param", "protocol", "protocol-param"},
+	"vmess": {"uuid", "alterId", "cipher", "tls", "servername", "skip-cert-verify", "alpn", "fingerprint", "client-fingerprint",
+		"network", "ws-opts", "grpc-opts", "h2-opts", "http-opts", "reality-opts", "packet-encoding", "global-padding", "authenticated-length"},
+	"vless": {"uuid", "flow", "tls", "servername", "skip-cert-verify", "alpn", "fingerprint", "client-fingerprint",
+		"network", "ws-opts", "grpc-opts", "h2-opts", "http-opts", "reality-opts", "packet-encoding", "encryption"},
+	"trojan": {"password", "sni", "skip-cert-verify", "alpn", "fingerprint", "client-fingerprint",
+		"network", "ws-opts", "grpc-opts", "reality-opts", "ss-opts"},
+	"hysteria2": {"password", "ports", "up", "down", "obfs", "obfs-password", "sni", "skip-cert-verify", "alpn", "fingerprint", "ca", "ca-str", "cwnd", "udp-mtu"},
+}
+
+// Keys mihomo accepts inside the option maps
+var optionKeys = map[string][]string{
+	"ws-opts":      {"path", "headers", "max-early-data", "early-data-header-name", "v2ray-http-upgrade", "v2ray-http-upgrade-fast-open"},
+	"grpc-opts":    {"grpc-service-name"},
+	"reality-opts": {"public-key", "short-id"},
+}
+
+const uuid = "b831381d-6324-4d53-ad4f-8cda48b30811"
+
+func TestConvertKeys(t *testing.T) {
+	yes, no := true, false
+	tests := []struct {
+		name string
+		node types.Proxy
+		want map[string]any // Expected values of some keys
+	}{
+		{"ss", types.Proxy{
+			Type: "ss", Server: "ss.example.com", Port: 8388, Cipher: "aes-256-gcm", Password: "secret", UDP: &yes,
+		}, map[string]any{"cipher": "aes-256-gcm", "udp": true}},
+		{"ss_udp_failed", types.Proxy{
+			Type: "ss", Server: "ss.example.com", Port: 8388, Cipher: "aes-256-gcm", Password: "secret", UDP: &no,
+		}, map[string]any{"udp": false}},
+		{"ssr", types.Proxy{
+			Type: "ssr", Server: "ssr.example.com", Port: 8989, Cipher: "aes-256-cfb", Password: "secret",
+			Protocol: "auth_aes128_md5", ProtocolParam: "1:abc", Obfs: "tls1.2_ticket_auth", ObfsParam: "cdn.example.com",
+		}, map[string]any{"protocol": "auth_aes128_md5", "obfs-param": "cdn.example.com"}},
+		{"vmess_ws_tls", types.Proxy{
+			Type: "vmess", Server: "vm.example.com", Port: 443, UUID: uuid, AlterID: 0,
+			Network: "ws", WSOpts: map[string]string{"path": "/ws", "host": "cdn.example.com"},
+			TLS: true, SNI: "cdn.example.com", SkipCertVerify: true,
+		}, map[string]any{"network": "ws", "servername": "cdn.example.com", "alterId": 0, "cipher": "auto"}},
+		{"vless_reality", types.Proxy{
+			Type: "vless", Server: "reality.example.com", Port: 443, UUID: uuid, Flow: "xtls-rprx-vision",
+			TLS: true, SNI: "www.microsoft.com",
+			RealityOpts: map[string]string{"public-key": "Z84J2IelR9ch3k8VtlVhhs5ycBUlXA7wHBWcBrjqnAw", "short-id": "6ba85179e30d4fc2"},
+		}, map[string]any{"flow": "xtls-rprx-vision", "client-fingerprint": "chrome"}},
+		{"vless_grpc", types.Proxy{
+			Type: "vless", Server: "grpc.example.com", Port: 443, UUID: uuid,
+			Network: "grpc", GrpcOpts: map[string]string{"grpc-service-name": "tunnel"}, TLS: true,
+		}, map[string]any{"network": "grpc"}},
+		{"vless_httpupgrade", types.Proxy{
+			Type: "vless", Server: "hu.example.com", Port: 80, UUID: uuid,
+			Network: "httpupgrade", WSOpts: map[string]string{"path": "/up", "host": "hu.example.com"},
+		}, map[string]any{"network": "ws"}},
+		{"trojan", types.Proxy{
+			Type: "trojan", Server: "trojan.example.com", Port: 443, Password: "secret", SNI: "trojan.example.com",
+			ALPN: []string{"h2", "http/1.1"},
+		}, map[string]any{"sni": "trojan.example.com"}},
+		{"hysteria2", types.Proxy{
+			Type: "hysteria2", Server: "hy2.example.com", Port: 443, Password: "secret",
+			Obfs: "salamander", ObfsPassword: "obfs", SNI: "hy2.example.com",
+		}, map[string]any{"obfs": "salamander", "obfs-password": "obfs"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node := tt.node
+			node.Name = tt.name
+			// Test results that must not leak into the proxy entry
+			node.Speed, node.Latency = 2048, 120
+
+			proxy, err := Convert(node)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data, err := yaml.Marshal(proxy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var entry map[string]any
+			if err := yaml.Unmarshal(data, &entry); err != nil {
+				t.Fatal(err)
+			}
+
+			allowed := make(map[string]bool)
+			for _, key := range append(append([]string{}, commonKeys...), mihomoKeys[node.Type]...) {
+				allowed[key] = true
+			}
+			for key, value := range entry {
+				if !allowed[key] {
+					t.Errorf("%s entries do not accept %q", node.Type, key)
+				}
+				if opts, ok := optionKeys[key]; ok {
+					assertKeys(t, key, value, opts)
+				}
+			}
+			for _, key := range []string{"host", "speed", "latency"} {
+				if _, ok := entry[key]; ok {
+					t.Errorf("entry has %q", key)
+				}
+			}
+			if _, ok := entry["alterId"]; ok && node.Type != "vmess" {
+				t.Errorf("%s entry has alterId", node.Type)
+			}
+			if _, ok := entry["udp"]; ok != (node.UDP != nil) {
+				t.Errorf("udp written: %t, want it only for tested nodes", ok)
+			}
+			for key, want := range tt.want {
+				if entry[key] != want {
+					t.Errorf("%s = %v, want %v", key, entry[key], want)
+				}
+			}
+
+			if host := node.WSOpts["host"]; host != "" {
+				ws, _ := entry["ws-opts"].(map[string]any)
+				headers, _ := ws["headers"].(map[string]any)
+				if headers["Host"] != host {
+					t.Errorf("ws-opts = %v, want headers.Host %s", entry["ws-opts"], host)
+				}
+			}
+		})
+	}
+}
+
+// assertKeys checks that the option map value only uses the allowed keys
+func assertKeys(t *testing.T, name string, value any, allowed []string) {
+	t.Helper()
+	opts, ok := value.(map[string]any)
+	if !ok {
+		t.Errorf("%s is %T, want a map", name, value)
+		return
+	}
+	for key := range opts {
+		found := false
+		for _, a := range allowed {
+			if key == a {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("%s does not accept %q", name, key)
+		}
+	}
+}
+
+func TestConvertUnsupported(t *testing.T) {
+	for _, node := range []types.Proxy{
+		{Name: "tuic", Type: "tuic", Server: "tuic.example.com", Port: 443},
+		{Name: "xhttp", Type: "vless", Server: "x.example.com", Port: 443, UUID: uuid, Network: "splithttp"},
+	} {
+		if _, err := Convert(node); err == nil {
+			t.Errorf("Convert(%s) succeeded, want an error", node.Name)
+		}
+	}
+	proxies := ConvertAll([]types.Proxy{{Name: "tuic", Type: "tuic"}, {Name: "ss", Type: "ss"}}, nil)
+	if len(proxies) != 1 || proxies[0].Name != "ss" {
+		t.Errorf("ConvertAll() = %v, want only the ss node", proxies)
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"log"
+
+	"subs-check-custom/types"
+)
+
+// Deduplication strategies selectable with dedup-strategy in config.yaml
+const (
+	DedupStrict   = "strict"    // Same protocol, credentials, endpoint and transport
+	DedupEndpoint = "endpoint"  // Same server:port regardless of credentials or transport
+	DedupEgressIP = "egress-ip" // Same exit IP once tested, strict before that
+)
+
+// dedupKey returns the key nodes are merged on for the given strategy
+func dedupKey(node types.Proxy, strategy string) string {
+	switch strategy {
+	case DedupEndpoint:
+		return node.Endpoint()
+	case DedupEgressIP:
+		if node.EgressIP != "" {
+			return "egress:" + node.EgressIP
+		}
+		return node.Fingerprint()
+	default:
+		return node.Fingerprint()
+	}
+}
+
+// dedupNodes keeps the first node seen for every key and logs each merge.
+// Callers that care which duplicate survives should sort nodes beforehand.
+func dedupNodes(nodes []types.Proxy, strategy string, simpleLogger *log.Logger) []types.Proxy {
+	switch strategy {
+	case DedupStrict, DedupEndpoint, DedupEgressIP:
+	case "":
+		strategy = DedupStrict
+	default:
+		log.Printf("Unknown dedup strategy '%s', defaulting to %s", strategy, DedupStrict)
+		strategy = DedupStrict
+	}
+
+	kept := make(map[string]types.Proxy)
+	uniqueNodes := make([]types.Proxy, 0, len(nodes))
+	for _, node := range nodes {
+		key := dedupKey(node, strategy)
+		if first, ok := kept[key]; ok {
+			log.Printf("Dedup (%s): merged %s (%s:%d) into %s (%s:%d), key %s", strategy, node.Name, node.Server, node.Port, first.Name, first.Server, first.Port, key)
+			if simpleLogger != nil {
+				simpleLogger.Printf("Dedup (%s): merged %s into %s", strategy, node.Name, first.Name)
+			}
+			continue
+		}
+		kept[key] = node
+		uniqueNodes = append(uniqueNodes, node)
+	}
+	return uniqueNodes
+}
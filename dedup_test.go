@@ -0,0 +1,76 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	"subs-check-custom/types"
+)
+
+func TestDedupNodes(t *testing.T) {
+	uuid := "b831381d-6324-4d53-ad4f-8cda48b30811"
+	nodes := []types.Proxy{
+		{Name: "hk-ws", Type: "vless", Server: "hk.example.com", Port: 443, UUID: uuid, Network: "ws", WSOpts: map[string]string{"path": "/a"}},
+		{Name: "hk-ws-renamed", Type: "vless", Server: "HK.example.com", Port: 443, UUID: uuid, Network: "ws", WSOpts: map[string]string{"path": "/a"}},
+		{Name: "hk-ws-other-path", Type: "vless", Server: "hk.example.com", Port: 443, UUID: uuid, Network: "ws", WSOpts: map[string]string{"path": "/b"}},
+		{Name: "hk-other-user", Type: "vless", Server: "hk.example.com", Port: 443, UUID: "c831381d-6324-4d53-ad4f-8cda48b30811", Network: "ws", WSOpts: map[string]string{"path": "/a"}},
+		{Name: "jp", Type: "trojan", Server: "jp.example.com", Port: 443, Password: "x"},
+	}
+
+	tests := []struct {
+		strategy string
+		want     []string
+	}{
+		{DedupStrict, []string{"hk-ws", "hk-ws-other-path", "hk-other-user", "jp"}},
+		{"", []string{"hk-ws", "hk-ws-other-path", "hk-other-user", "jp"}},
+		{"unknown", []string{"hk-ws", "hk-ws-other-path", "hk-other-user", "jp"}},
+		{DedupEndpoint, []string{"hk-ws", "jp"}},
+		// Untested nodes have no exit IP yet and fall back to strict
+		{DedupEgressIP, []string{"hk-ws", "hk-ws-other-path", "hk-other-user", "jp"}},
+	}
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	for _, tt := range tests {
+		t.Run(tt.strategy, func(t *testing.T) {
+			var names []string
+			for _, node := range dedupNodes(nodes, tt.strategy, nil) {
+				names = append(names, node.Name)
+			}
+			if strings.Join(names, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("kept %v, want %v", names, tt.want)
+			}
+		})
+	}
+}
+
+func TestDedupEgressIP(t *testing.T) {
+	nodes := []types.Proxy{
+		{Name: "relay-slow", Type: "trojan", Server: "a.example.com", Port: 443, Password: "a", EgressIP: "203.0.113.1", Speed: 200, Latency: 90},
+		{Name: "other-exit", Type: "trojan", Server: "b.example.com", Port: 443, Password: "b", EgressIP: "203.0.113.2", Speed: 500, Latency: 150},
+		{Name: "relay-fast", Type: "vmess", Server: "c.example.com", Port: 443, UUID: "b831381d-6324-4d53-ad4f-8cda48b30811", EgressIP: "203.0.113.1", Speed: 900, Latency: 300},
+		{Name: "untested", Type: "trojan", Server: "d.example.com", Port: 443, Password: "d"},
+	}
+	var out bytes.Buffer
+	logger := log.New(&out, "", 0)
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	// As in saveResults: sort best first so the faster duplicate survives
+	sortNodes(nodes, "", nil, logger)
+	kept := dedupNodes(nodes, DedupEgressIP, logger)
+
+	var names []string
+	for _, node := range kept {
+		names = append(names, node.Name)
+	}
+	if want := "relay-fast,other-exit,untested"; strings.Join(names, ",") != want {
+		t.Errorf("kept %v, want %s", names, want)
+	}
+	if !strings.Contains(out.String(), "Dedup (egress-ip): merged relay-slow into relay-fast") {
+		t.Errorf("log = %q, want the merge reported", out.String())
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/net/proxy"
+
+	"subs-check-custom/types"
+)
+
+const defaultEgressEchoURL = "https://api.ipify.org"
+
+// egressTest requests cfg.EgressEchoURL through every node and records the exit
+// IP. runPipeline collapses nodes sharing an exit once speeds are known.
+func egressTest(cfg types.Config, nodes []types.Proxy, sched *testScheduler, testLogger *log.Logger, dropFailed bool) []types.Proxy {
+	echoURL := cfg.EgressEchoURL
+	if echoURL == "" {
+		echoURL = defaultEgressEchoURL
+	}
+
+	results, counts := sched.run("Egress IP", nodes, func(nodeIndex int, n *types.Proxy, proxyAddr string) error {
+		client, err := newProxyClient(proxyAddr, time.Duration(cfg.Timeout)*time.Millisecond)
+		if err != nil {
+			testLogger.Printf("Node %d: Egress IP - Failed to create SOCKS5 dialer for %s (%v)", nodeIndex, proxyAddr, err)
+			return err
+		}
+		var ip string
+		err = sched.retry.do(func(attempt int) error {
+			ip, err = fetchEgressIP(client, echoURL)
+			return err
+		})
+		if err != nil {
+			testLogger.Printf("Node %d: Egress IP - Lookup failed for %s (%v)", nodeIndex, n.Name, err)
+			return err
+		}
+		n.EgressIP = ip
+		testLogger.Printf("Node %d: Egress IP - %s exits via %s", nodeIndex, n.Name, ip)
+		return nil
+	})
+	testLogger.Printf("Egress IP - Resolved %d/%d nodes, Failures: %s", counts.Passed, counts.Total, formatFailures(counts.Failures))
+
+	return keepResults(nodes, results, dropFailed)
+}
+
+// newProxyClient returns an HTTP client that dials through the SOCKS5 proxy at proxyAddr
+func newProxyClient(proxyAddr string, timeout time.Duration) (*http.Client, error) {
+	dialer, err := proxy.SOCKS5("tcp", proxyAddr, nil, proxy.Direct)
+	if err != nil {
+		return nil, err
+	}
+	adapter := &dialContextAdapter{dialer: dialer}
+	return &http.Client{
+		Transport: &http.Transport{DialContext: adapter.DialContext},
+		Timeout:   timeout,
+	}, nil
+}
+
+// fetchEgressIP asks echoURL for the caller's IP. Plain-text bodies and JSON
+// bodies with an "ip", "origin" or "query" field are both accepted.
+func fetchEgressIP(client *http.Client, echoURL string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), client.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", echoURL, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", statusError{resp.StatusCode}
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if err != nil {
+		return "", err
+	}
+
+	text := strings.TrimSpace(string(body))
+	var fields map[string]interface{}
+	if json.Unmarshal(body, &fields) == nil {
+		for _, key := range []string{"ip", "origin", "query"} {
+			if v, ok := fields[key].(string); ok {
+				// httpbin's origin may list several comma-separated addresses
+				text = strings.TrimSpace(strings.Split(v, ",")[0])
+				break
+			}
+		}
+	}
+	ip := net.ParseIP(text)
+	if ip == nil {
+		return "", fmt.Errorf("unexpected echo response %q", text)
+	}
+	return ip.String(), nil
+}
+
+// collapseByEgress keeps the fastest node per egress IP, preferring higher speed
+// and then lower latency. Nodes without a resolved egress IP are kept as is.
+func collapseByEgress(nodes []types.Proxy, testLogger *log.Logger) []types.Proxy {
+	best := make(map[string]int)
+	for i, node := range nodes {
+		if node.EgressIP == "" {
+			continue
+		}
+		j, ok := best[node.EgressIP]
+		if !ok || fasterThan(node, nodes[j]) {
+			best[node.EgressIP] = i
+		}
+	}
+
+	collapsed := make([]types.Proxy, 0, len(nodes))
+	defer func() {
+		testLogger.Printf("Egress IP - Collapsed %d nodes to %d unique exits", len(nodes), len(collapsed))
+	}()
+	for i, node := range nodes {
+		if node.EgressIP != "" && best[node.EgressIP] != i {
+			keep := nodes[best[node.EgressIP]]
+			testLogger.Printf("Egress IP - Merged %s into %s (exit %s)", node.Name, keep.Name, node.EgressIP)
+			continue
+		}
+		collapsed = append(collapsed, node)
+	}
+	return collapsed
+}
+
+// fasterThan reports whether a should be preferred over b
+func fasterThan(a, b types.Proxy) bool {
+	if a.Speed != b.Speed {
+		return a.Speed > b.Speed
+	}
+	if a.Latency > 0 && b.Latency > 0 {
+		return a.Latency < b.Latency
+	}
+	return a.Latency > 0 && b.Latency == 0
+}
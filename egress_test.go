@@ -0,0 +1,97 @@
+package main
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"subs-check-custom/types"
+)
+
+func TestFetchEgressIP(t *testing.T) {
+	tests := []struct {
+		name    string
+		status  int
+		body    string
+		want    string
+		wantErr bool
+	}{
+		{name: "plain text", status: http.StatusOK, body: "203.0.113.7\n", want: "203.0.113.7"},
+		{name: "json ip", status: http.StatusOK, body: `{"ip":"2001:db8::1"}`, want: "2001:db8::1"},
+		{name: "json origin list", status: http.StatusOK, body: `{"origin":"198.51.100.2, 10.0.0.1"}`, want: "198.51.100.2"},
+		{name: "garbage", status: http.StatusOK, body: "<html>hello</html>", wantErr: true},
+		{name: "bad status", status: http.StatusBadGateway, body: "203.0.113.7", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.status)
+				io.WriteString(w, tt.body)
+			}))
+			defer server.Close()
+
+			got, err := fetchEgressIP(&http.Client{Timeout: 5 * time.Second}, server.URL)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("fetchEgressIP() = %q, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("fetchEgressIP() error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("fetchEgressIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCollapseByEgress(t *testing.T) {
+	nodes := []types.Proxy{
+		{Name: "a-slow", EgressIP: "203.0.113.1", Speed: 100, Latency: 50},
+		{Name: "a-fast", EgressIP: "203.0.113.1", Speed: 900, Latency: 300},
+		{Name: "b-far", EgressIP: "203.0.113.2", Latency: 400},
+		{Name: "b-near", EgressIP: "203.0.113.2", Latency: 80},
+		{Name: "unknown-1"},
+		{Name: "unknown-2"},
+	}
+	got := collapseByEgress(nodes, log.New(io.Discard, "", 0))
+
+	want := []string{"a-fast", "b-near", "unknown-1", "unknown-2"}
+	if len(got) != len(want) {
+		t.Fatalf("collapseByEgress() kept %d nodes, want %d", len(got), len(want))
+	}
+	for i, name := range want {
+		if got[i].Name != name {
+			t.Errorf("node %d = %s, want %s", i, got[i].Name, name)
+		}
+	}
+}
+
+func TestEgressCollapsePoint(t *testing.T) {
+	stages := func(names ...string) []types.TestStage {
+		var s []types.TestStage
+		for _, name := range names {
+			s = append(s, types.TestStage{Stage: name})
+		}
+		return s
+	}
+	tests := []struct {
+		stages []types.TestStage
+		want   int
+	}{
+		{stages(StageTCP, StageDownload), -1},
+		{stages(StageTCP, StageEgressIP), 1},
+		{stages(StageTCP, StageEgressIP, StageUDP, StageDownload, StageUpload), 3},
+		{stages(StageDownload, StageEgressIP), 1},
+	}
+	for _, tt := range tests {
+		if got := egressCollapsePoint(tt.stages); got != tt.want {
+			t.Errorf("egressCollapsePoint(%v) = %d, want %d", tt.stages, got, tt.want)
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"encoding/base64"
 	"fmt"
 	"log"
+	"net/url"
 	"os"
 	"os/exec"
 	"strings"
@@ -13,9 +14,15 @@ import (
 	"subs-check-custom/types"
 )
 
-func fetchContent(config types.Config, updateProgress func(string)) (string, error) {
+// subscription is the decoded content of one subscription URL
+type subscription struct {
+	Source  string // Host of the subscription URL, used to group its nodes
+	Content string
+}
+
+func fetchContent(config types.Config, updateProgress func(string)) ([]subscription, error) {
 	updateProgress("Fetching")
-	var allContent strings.Builder
+	var subs []subscription
 	for _, subURL := range config.SubURLs {
 		if subURL == "" {
 			continue
@@ -55,30 +62,66 @@ func fetchContent(config types.Config, updateProgress func(string)) (string, err
 		if err != nil {
 			log.Printf("Failed to read decodedOriginal.txt for %s: %v", subURL, err)
 			log.Printf("Subscription maybe not in base64 format.")
-			allContent.WriteString(string(output) + "\n")
-		} else {
-			allContent.WriteString(string(content) + "\n")
+			content = output
 		}
+		subs = append(subs, subscription{Source: subscriptionSource(subURL), Content: string(content) + "\n"})
 	}
 
-	if allContent.Len() == 0 {
+	if len(subs) == 0 {
 		log.Printf("No content fetched from any subscription URLs")
-		return "", fmt.Errorf("no content fetched")
+		return nil, fmt.Errorf("no content fetched")
+	}
+
+	return subs, nil
+}
+
+// subscriptionSource names a subscription after the host of its URL
+func subscriptionSource(subURL string) string {
+	if u, err := url.Parse(subURL); err == nil && u.Host != "" {
+		return u.Hostname()
+	}
+	return subURL
+}
+
+func fetchNodes(config types.Config, subs []subscription, simpleLogger *log.Logger, stats *types.ProxyStats) []types.Proxy {
+	var proxies []types.Proxy
+	i := -1 // Line number across all subscriptions
+	for _, sub := range subs {
+		proxies = append(proxies, parseSubscription(sub, &i, len(proxies), simpleLogger, stats)...)
+	}
+
+	// Deduplicate nodes using the configured strategy
+	if len(proxies) > 0 {
+		uniqueProxies := dedupNodes(proxies, config.DedupStrategy, simpleLogger)
+		log.Printf("Parsed %d nodes, reduced to %d unique nodes after deduplication", len(proxies), len(uniqueProxies))
+		simpleLogger.Printf("Parsed %d nodes, reduced to %d unique nodes after deduplication", len(proxies), len(uniqueProxies))
+		fmt.Printf("Parsed nodes after deduplication: %d\n", len(uniqueProxies)) // Print to screen
+		proxies = uniqueProxies
+	}
+
+	if len(proxies) == 0 {
+		log.Printf("No valid proxies parsed, adding default node")
+		proxies = []types.Proxy{{Name: "No usable nodes"}}
+		simpleLogger.Printf("No valid proxies parsed - Added default node")
+		fmt.Printf("Fetch nodes after deduplication: %d\n", len(proxies)) // Print even if no nodes
 	}
 
-	return allContent.String(), nil
+	return proxies
 }
 
-func fetchNodes(subscriptionContent string, simpleLogger *log.Logger, stats *types.ProxyStats) []types.Proxy {
-	decodedBody, err := base64.StdEncoding.DecodeString(subscriptionContent)
+// parseSubscription parses every line of one subscription. lineNo is the running
+// line number used in logs and parsed is the number of nodes parsed before it.
+func parseSubscription(sub subscription, lineNo *int, parsed int, simpleLogger *log.Logger, stats *types.ProxyStats) []types.Proxy {
+	decodedBody, err := base64.StdEncoding.DecodeString(sub.Content)
 	if err != nil {
-		log.Printf("Initial Base64 decode failed, treating as raw data: %v", err)
-		decodedBody = []byte(subscriptionContent)
+		log.Printf("Initial Base64 decode failed for %s, treating as raw data: %v", sub.Source, err)
+		decodedBody = []byte(sub.Content)
 	}
 
 	var proxies []types.Proxy
-	lines := strings.Split(string(decodedBody), "\n")
-	for i, line := range lines {
+	for _, line := range strings.Split(string(decodedBody), "\n") {
+		*lineNo++
+		i := *lineNo
 		line = strings.TrimSpace(line)
 		line = strings.Trim(line, "\r\n")
 		if line == "" {
@@ -91,6 +134,8 @@ func fetchNodes(subscriptionContent string, simpleLogger *log.Logger, stats *typ
 		switch {
 		case strings.HasPrefix(line, "vmess://"):
 			proxy = parsers.ParseVMess(line, i, simpleLogger, stats)
+		case strings.HasPrefix(line, "ssr://"):
+			proxy = parsers.ParseSSR(line, i, simpleLogger, stats)
 		case strings.HasPrefix(line, "ss://"):
 			proxy = parsers.ParseSS(line, i, simpleLogger, stats)
 		case strings.HasPrefix(line, "trojan://"):
@@ -105,36 +150,12 @@ func fetchNodes(subscriptionContent string, simpleLogger *log.Logger, stats *typ
 		}
 
 		if proxy != nil {
-			if proxy.Name == "SS_Proxy_0" || proxy.Name == "Trojan_Proxy_0" || proxy.Name == "Hysteria2_Proxy_0" || proxy.Name == "VLess_Proxy_0" {
-				proxy.Name = fmt.Sprintf("%s_Proxy_%d", proxy.Type, len(proxies))
+			if proxy.Name == "VMess_Proxy_0" || proxy.Name == "SS_Proxy_0" || proxy.Name == "SSR_Proxy_0" || proxy.Name == "Trojan_Proxy_0" || proxy.Name == "Hysteria2_Proxy_0" || proxy.Name == "VLess_Proxy_0" {
+				proxy.Name = fmt.Sprintf("%s_Proxy_%d", proxy.Type, parsed+len(proxies))
 			}
+			proxy.Source = sub.Source
 			proxies = append(proxies, *proxy)
 		}
 	}
-
-	// Deduplicate nodes based on Server:Port
-	if len(proxies) > 0 {
-		seen := make(map[string]bool)
-		uniqueProxies := []types.Proxy{}
-		for _, proxy := range proxies {
-			key := fmt.Sprintf("%s:%d", proxy.Server, proxy.Port)
-			if !seen[key] {
-				seen[key] = true
-				uniqueProxies = append(uniqueProxies, proxy)
-			}
-		}
-		log.Printf("Parsed %d nodes, reduced to %d unique nodes after deduplication", len(proxies), len(uniqueProxies))
-		simpleLogger.Printf("Parsed %d nodes, reduced to %d unique nodes after deduplication", len(proxies), len(uniqueProxies))
-		fmt.Printf("Parsed nodes after deduplication: %d\n", len(uniqueProxies)) // Print to screen
-		proxies = uniqueProxies
-	}
-
-	if len(proxies) == 0 {
-		log.Printf("No valid proxies parsed, adding default node")
-		proxies = []types.Proxy{{Name: "No usable nodes"}}
-		simpleLogger.Printf("No valid proxies parsed - Added default node")
-		fmt.Printf("Fetch nodes after deduplication: %d\n", len(proxies)) // Print even if no nodes
-	}
-
 	return proxies
 }
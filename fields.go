@@ -0,0 +1,134 @@
+package main
+
+import (
+	"strings"
+
+	"subs-check-custom/types"
+)
+
+// nodeNumber returns a numeric field of node by name, as used by sorting, scoring
+// and filters. ok is false when the node has no value, e.g. it was not tested.
+func nodeNumber(node types.Proxy, key string) (value float64, ok bool) {
+	key = strings.ToLower(key)
+	if service, found := strings.CutPrefix(key, "unlock."); found {
+		verdict, tested := node.Tags[service]
+		if !tested {
+			return 0, false
+		}
+		if isUnlocked(verdict) {
+			return 1, true
+		}
+		return 0, true
+	}
+
+	switch key {
+	case "speed":
+		return node.Speed, node.Speed > 0
+	case "peak-speed":
+		return node.PeakSpeed, node.PeakSpeed > 0
+	case "upload":
+		return node.UploadSpeed, node.UploadSpeed > 0
+	case "latency":
+		return float64(node.Latency), node.Latency > 0
+	case "port":
+		return float64(node.Port), true
+	case "udp":
+		if node.UDP == nil {
+			return 0, false
+		}
+		if *node.UDP {
+			return 1, true
+		}
+		return 0, true
+	case "udp-latency":
+		return node.UDPLatency, node.UDPLatency > 0
+	case "asn":
+		asn := node.Geo().ASN
+		return float64(asn), asn != 0
+	case "score":
+		return node.Score, true
+	case "stability", "uptime", "runs", "speed-trend", "history-latency":
+		if node.History == nil {
+			return 0, false
+		}
+		switch key {
+		case "stability":
+			return node.History.Stability, true
+		case "uptime":
+			return node.History.Uptime, true
+		case "runs":
+			return float64(node.History.Runs), true
+		case "speed-trend":
+			return node.History.SpeedTrend, true
+		}
+		return node.History.MedianLatency, node.History.MedianLatency > 0
+	}
+
+	if value, ok, err := latencyMetric(node, key); err == nil {
+		return value, ok
+	}
+	return 0, false
+}
+
+// nodeString returns a text field of node by name. ok is false when the node has no value.
+func nodeString(node types.Proxy, key string) (value string, ok bool) {
+	key = strings.ToLower(key)
+	if service, found := strings.CutPrefix(key, "unlock."); found {
+		verdict, tested := node.Tags[service]
+		return verdict, tested
+	}
+
+	switch key {
+	case "name":
+		return node.Name, true
+	case "type":
+		return node.Type, true
+	case "server":
+		return node.Server, true
+	case "network":
+		if node.Network == "" {
+			return "tcp", true
+		}
+		return node.Network, true
+	case "sni":
+		return node.SNI, node.SNI != ""
+	case "country":
+		country := node.Geo().Country
+		return country, country != ""
+	case "org":
+		org := node.Geo().Org
+		return org, org != ""
+	case "egress-ip":
+		return node.EgressIP, node.EgressIP != ""
+	case "failure":
+		if node.Failure == nil {
+			return "", false
+		}
+		return node.Failure.Class, true
+	}
+	return "", false
+}
+
+// isStringField reports whether key names a text field rather than a numeric one
+func isStringField(key string) bool {
+	switch strings.ToLower(key) {
+	case "name", "type", "server", "network", "sni", "country", "org", "egress-ip", "failure":
+		return true
+	}
+	return false
+}
+
+// isNumberField reports whether key names a numeric field
+func isNumberField(key string) bool {
+	key = strings.ToLower(key)
+	if strings.HasPrefix(key, "unlock.") {
+		return true
+	}
+	switch key {
+	case "speed", "peak-speed", "upload", "latency", "port", "udp", "udp-latency", "asn", "score",
+		"stability", "uptime", "runs", "speed-trend", "history-latency":
+		return true
+	}
+	_, _, err := latencyMetric(types.Proxy{}, key)
+	return err == nil
+}
@@ -0,0 +1,381 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"subs-check-custom/types"
+)
+
+// Points in the run where filters apply
+const (
+	FilterAfterParse = "parse"
+	FilterAfterTest  = "test"
+)
+
+// applyFilters runs the configured filters for the given point in order. An
+// include filter keeps the matching nodes and an exclude filter drops them.
+func applyFilters(cfg types.Config, nodes []types.Proxy, when string, logger *log.Logger) []types.Proxy {
+	for i, rule := range cfg.Filters {
+		ruleWhen := strings.ToLower(rule.When)
+		if ruleWhen == "" {
+			ruleWhen = FilterAfterTest
+		}
+		label := rule.Name
+		if label == "" {
+			label = fmt.Sprintf("#%d", i+1)
+		}
+		if ruleWhen != FilterAfterParse && ruleWhen != FilterAfterTest {
+			// Parse filters run first on every run, so report it once there
+			if when == FilterAfterParse {
+				logger.Printf("Filter %s: skipped, unknown when %q (use %s or %s)", label, rule.When, FilterAfterParse, FilterAfterTest)
+			}
+			continue
+		}
+		if ruleWhen != when {
+			continue
+		}
+		expr, err := parseFilter(rule.Expr)
+		if err != nil {
+			logger.Printf("Filter %s: skipped, invalid expression %q (%v)", label, rule.Expr, err)
+			continue
+		}
+		exclude := strings.EqualFold(rule.Action, "exclude")
+
+		kept := make([]types.Proxy, 0, len(nodes))
+		matched := 0
+		for _, node := range nodes {
+			match := expr.eval(node)
+			if match {
+				matched++
+			}
+			if match != exclude {
+				kept = append(kept, node)
+			}
+		}
+		logger.Printf("Filter %s (after %s): %d of %d nodes matched %q, %d kept", label, when, matched, len(nodes), rule.Expr, len(kept))
+		nodes = kept
+	}
+	return nodes
+}
+
+// filterExpr is a parsed filter expression
+type filterExpr interface {
+	eval(node types.Proxy) bool
+}
+
+type (
+	andExpr struct{ left, right filterExpr }
+	orExpr  struct{ left, right filterExpr }
+	notExpr struct{ expr filterExpr }
+	// fieldExpr is a bare field, true when it is set and non-zero
+	fieldExpr struct{ field string }
+	// compareExpr compares a field with one value, or a list for in
+	compareExpr struct {
+		field  string
+		op     string
+		values []string
+		re     *regexp.Regexp
+	}
+)
+
+func (e andExpr) eval(node types.Proxy) bool { return e.left.eval(node) && e.right.eval(node) }
+func (e orExpr) eval(node types.Proxy) bool  { return e.left.eval(node) || e.right.eval(node) }
+func (e notExpr) eval(node types.Proxy) bool { return !e.expr.eval(node) }
+
+func (e fieldExpr) eval(node types.Proxy) bool {
+	if isStringField(e.field) {
+		v, ok := nodeString(node, e.field)
+		return ok && v != ""
+	}
+	v, ok := nodeNumber(node, e.field)
+	return ok && v != 0
+}
+
+// eval compares as text for text fields, regex operators and non-numeric values,
+// and as numbers otherwise. A missing text field compares as "". A missing
+// numeric field only satisfies !=.
+func (e compareExpr) eval(node types.Proxy) bool {
+	text := isStringField(e.field) || e.re != nil
+	numbers := make([]float64, len(e.values))
+	for i, value := range e.values {
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			text = true
+			break
+		}
+		numbers[i] = n
+	}
+
+	if text {
+		v, _ := nodeString(node, e.field)
+		switch e.op {
+		case "=~":
+			return e.re.MatchString(v)
+		case "!~":
+			return !e.re.MatchString(v)
+		case "==", "in":
+			for _, value := range e.values {
+				if strings.EqualFold(v, value) {
+					return true
+				}
+			}
+			return false
+		case "!=":
+			return !strings.EqualFold(v, e.values[0])
+		case "<":
+			return v < e.values[0]
+		case "<=":
+			return v <= e.values[0]
+		case ">":
+			return v > e.values[0]
+		case ">=":
+			return v >= e.values[0]
+		}
+		return false
+	}
+
+	v, ok := nodeNumber(node, e.field)
+	if !ok {
+		return e.op == "!="
+	}
+	switch e.op {
+	case "==", "in":
+		for _, n := range numbers {
+			if v == n {
+				return true
+			}
+		}
+		return false
+	case "!=":
+		return v != numbers[0]
+	case "<":
+		return v < numbers[0]
+	case "<=":
+		return v <= numbers[0]
+	case ">":
+		return v > numbers[0]
+	case ">=":
+		return v >= numbers[0]
+	}
+	return false
+}
+
+// parseFilter parses an expression such as
+//
+//	type in [vless, trojan] && country != "CN" && latency < 800 && !name =~ "剩余|过期"
+//
+// Operators are ==, !=, <, <=, >, >=, =~ and !~ (regular expressions) and in,
+// combined with &&, || and ! and grouped with parentheses.
+func parseFilter(src string) (filterExpr, error) {
+	tokens, err := tokenizeFilter(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &filterParser{tokens: tokens, end: len([]rune(src))}
+	expr, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.tokens) {
+		return nil, fmt.Errorf("unexpected %q at %d", p.tokens[p.pos].text, p.tokens[p.pos].at)
+	}
+	return expr, nil
+}
+
+type filterToken struct {
+	text   string
+	quoted bool // A string literal rather than an operator or bare word
+	at     int  // Offset in runes of the token in the expression
+}
+
+// tokenizeFilter splits src into operators, punctuation, quoted strings and bare words
+func tokenizeFilter(src string) ([]filterToken, error) {
+	var tokens []filterToken
+	runes := []rune(src)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '"' || r == '\'':
+			var b strings.Builder
+			j := i + 1
+			for ; j < len(runes) && runes[j] != r; j++ {
+				if runes[j] == '\\' && j+1 < len(runes) {
+					j++
+				}
+				b.WriteRune(runes[j])
+			}
+			if j >= len(runes) {
+				return nil, fmt.Errorf("unterminated string starting at %d", i)
+			}
+			tokens = append(tokens, filterToken{text: b.String(), quoted: true, at: i})
+			i = j + 1
+		case strings.ContainsRune("()[],", r):
+			tokens = append(tokens, filterToken{text: string(r), at: i})
+			i++
+		case strings.ContainsRune("=!<>&|", r):
+			op := string(r)
+			if i+1 < len(runes) {
+				if two := string(runes[i : i+2]); two == "==" || two == "!=" || two == "<=" || two == ">=" ||
+					two == "=~" || two == "!~" || two == "&&" || two == "||" {
+					op = two
+				}
+			}
+			if op == "=" || op == "&" || op == "|" {
+				return nil, fmt.Errorf("unknown operator %q at %d", op, i)
+			}
+			tokens = append(tokens, filterToken{text: op, at: i})
+			i += len(op)
+		default:
+			j := i
+			for j < len(runes) && !unicode.IsSpace(runes[j]) && !strings.ContainsRune("()[],=!<>&|\"'", runes[j]) {
+				j++
+			}
+			tokens = append(tokens, filterToken{text: string(runes[i:j]), at: i})
+			i = j
+		}
+	}
+	return tokens, nil
+}
+
+type filterParser struct {
+	tokens []filterToken
+	pos    int
+	end    int // Length of the expression, the position of errors at its end
+}
+
+func (p *filterParser) peek() string {
+	if p.pos < len(p.tokens) && !p.tokens[p.pos].quoted {
+		return p.tokens[p.pos].text
+	}
+	return ""
+}
+
+// at returns the position of the next token, or the end of the expression
+func (p *filterParser) at() int {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos].at
+	}
+	return p.end
+}
+
+func (p *filterParser) next() (filterToken, error) {
+	if p.pos >= len(p.tokens) {
+		return filterToken{}, fmt.Errorf("unexpected end of expression at %d", p.end)
+	}
+	p.pos++
+	return p.tokens[p.pos-1], nil
+}
+
+func (p *filterParser) parseOr() (filterExpr, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "||" {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = orExpr{left, right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (filterExpr, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "&&" {
+		p.pos++
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = andExpr{left, right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseUnary() (filterExpr, error) {
+	switch p.peek() {
+	case "!":
+		p.pos++
+		expr, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return notExpr{expr}, nil
+	case "(":
+		p.pos++
+		expr, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, fmt.Errorf("missing ) at %d", p.at())
+		}
+		p.pos++
+		return expr, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *filterParser) parseComparison() (filterExpr, error) {
+	tok, err := p.next()
+	if err != nil {
+		return nil, err
+	}
+	field := strings.ToLower(tok.text)
+	if tok.quoted || !(isStringField(field) || isNumberField(field)) {
+		return nil, fmt.Errorf("unknown field %q at %d", tok.text, tok.at)
+	}
+
+	op := p.peek()
+	switch op {
+	case "==", "!=", "<", "<=", ">", ">=", "=~", "!~":
+		p.pos++
+		value, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		expr := compareExpr{field: field, op: op, values: []string{value.text}}
+		if op == "=~" || op == "!~" {
+			if expr.re, err = regexp.Compile(value.text); err != nil {
+				return nil, fmt.Errorf("invalid regular expression at %d: %w", value.at, err)
+			}
+		}
+		return expr, nil
+	case "in":
+		p.pos++
+		if p.peek() != "[" {
+			return nil, fmt.Errorf("expected [ after in at %d", p.at())
+		}
+		p.pos++
+		var values []string
+		for p.peek() != "]" {
+			value, err := p.next()
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value.text)
+			if p.peek() == "," {
+				p.pos++
+			}
+		}
+		if len(values) == 0 {
+			return nil, fmt.Errorf("empty list after in at %d", p.at())
+		}
+		p.pos++
+		return compareExpr{field: field, op: "in", values: values}, nil
+	}
+	return fieldExpr{field}, nil
+}
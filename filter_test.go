@@ -0,0 +1,143 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"reflect"
+	"strings"
+	"testing"
+
+	"subs-check-custom/types"
+)
+
+func TestApplyFiltersWhen(t *testing.T) {
+	nodes := []types.Proxy{
+		{Name: "hk", Type: "trojan", ServerGeo: types.GeoInfo{Country: "HK"}},
+		{Name: "cn", Type: "vmess", ServerGeo: types.GeoInfo{Country: "CN"}},
+	}
+	cfg := types.Config{Filters: []types.FilterRule{
+		{Name: "no-cn", Expr: `country == "CN"`, Action: "exclude", When: "parse"},
+		{Name: "typo", Expr: `type == "vmess"`, When: "parsed"},
+	}}
+	var out bytes.Buffer
+	logger := log.New(&out, "", 0)
+
+	kept := applyFilters(cfg, nodes, FilterAfterParse, logger)
+	if len(kept) != 1 || kept[0].Name != "hk" {
+		t.Errorf("kept %v after parse, want only hk", kept)
+	}
+	if !strings.Contains(out.String(), `Filter typo: skipped, unknown when "parsed"`) {
+		t.Errorf("log = %q, want the unknown when reported", out.String())
+	}
+
+	out.Reset()
+	if kept := applyFilters(cfg, nodes, FilterAfterTest, logger); len(kept) != 2 {
+		t.Errorf("kept %d nodes after test, want both", len(kept))
+	}
+	if strings.Contains(out.String(), "typo") {
+		t.Errorf("log = %q, want the unknown when reported only once", out.String())
+	}
+}
+
+func TestTokenizeFilter(t *testing.T) {
+	tokens, err := tokenizeFilter(`type in [vless,trojan] && !name =~ "剩余|\"过期\"" || port>=443`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []filterToken{
+		{text: "type"}, {text: "in", at: 5}, {text: "[", at: 8}, {text: "vless", at: 9}, {text: ",", at: 14},
+		{text: "trojan", at: 15}, {text: "]", at: 21}, {text: "&&", at: 23}, {text: "!", at: 26}, {text: "name", at: 27},
+		{text: "=~", at: 32}, {text: `剩余|"过期"`, quoted: true, at: 35}, {text: "||", at: 47}, {text: "port", at: 50},
+		{text: ">=", at: 54}, {text: "443", at: 56},
+	}
+	if !reflect.DeepEqual(tokens, want) {
+		t.Errorf("tokens = %+v\nwant     %+v", tokens, want)
+	}
+}
+
+func TestFilterEval(t *testing.T) {
+	udp := true
+	nodes := map[string]types.Proxy{
+		"vless-hk": {Name: "香港 01", Type: "vless", Port: 443, Latency: 120, Speed: 2048, UDP: &udp,
+			ServerGeo: types.GeoInfo{Country: "US"}, EgressGeo: types.GeoInfo{Country: "HK", ASN: 4760}},
+		"trojan-cn":      {Name: "上海 02", Type: "trojan", Port: 443, Latency: 90, ServerGeo: types.GeoInfo{Country: "CN"}},
+		"trojan-slow":    {Name: "日本 03", Type: "trojan", Port: 8443, Latency: 1500, ServerGeo: types.GeoInfo{Country: "JP"}},
+		"expired":        {Name: "剩余流量：10GB", Type: "vless", Port: 443, Latency: 100},
+		"vmess-untested": {Name: "美国 04", Type: "vmess", Port: 80, ServerGeo: types.GeoInfo{Country: "US"}},
+	}
+
+	tests := []struct {
+		expr string
+		want []string
+	}{
+		// The example from the request
+		{`type in [vless, trojan] && country != "CN" && latency < 800 && !name =~ "剩余|过期"`, []string{"vless-hk"}},
+		// && binds tighter than ||
+		{`type == vmess || type == trojan && latency < 100`, []string{"trojan-cn", "vmess-untested"}},
+		{`(type == vmess || type == trojan) && latency < 100`, []string{"trojan-cn"}},
+		{`latency < 100 && type == trojan || type == vmess`, []string{"trojan-cn", "vmess-untested"}},
+		{`!(type == trojan) && !udp`, []string{"expired", "vmess-untested"}},
+		{`!!udp`, []string{"vless-hk"}},
+		// Text comparisons ignore case, regexes do not
+		{`country == hk`, []string{"vless-hk"}},
+		{`name =~ "^(香港|日本)"`, []string{"trojan-slow", "vless-hk"}},
+		{`name !~ "\\d{2}$"`, []string{"expired"}},
+		{`type in ["VMESS"]`, []string{"vmess-untested"}},
+		{`port in [80, 8443]`, []string{"trojan-slow", "vmess-untested"}},
+		{`asn in [4760, 4134]`, []string{"vless-hk"}},
+		// Untested numbers only satisfy !=, a missing text field compares as ""
+		{`latency >= 0`, []string{"expired", "trojan-cn", "trojan-slow", "vless-hk"}},
+		{`speed != 2048`, []string{"expired", "trojan-cn", "trojan-slow", "vmess-untested"}},
+		{`speed < 1`, nil},
+		{`!speed`, []string{"expired", "trojan-cn", "trojan-slow", "vmess-untested"}},
+		{`country == ""`, []string{"expired"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			expr, err := parseFilter(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, key := range []string{"expired", "trojan-cn", "trojan-slow", "vless-hk", "vmess-untested"} {
+				if expr.eval(nodes[key]) {
+					got = append(got, key)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("matched %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseFilterErrors(t *testing.T) {
+	tests := []struct {
+		expr string
+		want string
+	}{
+		{`name == "abc`, "unterminated string starting at 8"},
+		{`latency = 5`, `unknown operator "=" at 8`},
+		{`type == vless & port == 443`, `unknown operator "&" at 14`},
+		{`colour == red`, `unknown field "colour" at 0`},
+		{`"name" == x`, `unknown field "name" at 0`},
+		{`(type == vless`, "missing ) at 14"},
+		{`type == vless)`, `unexpected ")" at 13`},
+		{`type == vless port`, `unexpected "port" at 14`},
+		{`type ==`, "unexpected end of expression at 7"},
+		{`type == vless &&`, "unexpected end of expression at 16"},
+		{`type in vless`, "expected [ after in at 8"},
+		{`type in []`, "empty list after in at 9"},
+		{`type in [vless`, "unexpected end of expression at 14"},
+		{`name =~ "(" && 1`, "invalid regular expression at 8"},
+		{``, "unexpected end of expression at 0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			_, err := parseFilter(tt.expr)
+			if err == nil || !strings.HasPrefix(err.Error(), tt.want) {
+				t.Errorf("error = %v, want %s", err, tt.want)
+			}
+		})
+	}
+}
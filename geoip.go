@@ -0,0 +1,161 @@
+package main
+
+import (
+	"log"
+	"net"
+	"strings"
+
+	"github.com/oschwald/maxminddb-golang"
+
+	"subs-check-custom/types"
+)
+
+// geoIPReader looks up countries and ASNs in local MaxMind-format databases.
+// Either database may be missing.
+type geoIPReader struct {
+	country *maxminddb.Reader
+	asn     *maxminddb.Reader
+}
+
+type countryRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	RegisteredCountry struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"registered_country"`
+}
+
+type asnRecord struct {
+	Number uint   `maxminddb:"autonomous_system_number"`
+	Org    string `maxminddb:"autonomous_system_organization"`
+}
+
+// openGeoIP opens the configured databases. It returns nil when none is configured or opened.
+func openGeoIP(cfg types.Config, logger *log.Logger) *geoIPReader {
+	r := &geoIPReader{}
+	var err error
+	if cfg.GeoIPCountryDB != "" {
+		if r.country, err = maxminddb.Open(cfg.GeoIPCountryDB); err != nil {
+			logger.Printf("GeoIP - Failed to open country database %s: %v", cfg.GeoIPCountryDB, err)
+		}
+	}
+	if cfg.GeoIPASNDB != "" {
+		if r.asn, err = maxminddb.Open(cfg.GeoIPASNDB); err != nil {
+			logger.Printf("GeoIP - Failed to open ASN database %s: %v", cfg.GeoIPASNDB, err)
+		}
+	}
+	if r.country == nil && r.asn == nil {
+		return nil
+	}
+	return r
+}
+
+func (r *geoIPReader) Close() {
+	if r.country != nil {
+		r.country.Close()
+	}
+	if r.asn != nil {
+		r.asn.Close()
+	}
+}
+
+// lookup returns what the databases know about ip
+func (r *geoIPReader) lookup(ip net.IP) types.GeoInfo {
+	var info types.GeoInfo
+	if r.country != nil {
+		var rec countryRecord
+		if err := r.country.Lookup(ip, &rec); err == nil {
+			info.Country = rec.Country.ISOCode
+			if info.Country == "" {
+				info.Country = rec.RegisteredCountry.ISOCode
+			}
+		}
+	}
+	if r.asn != nil {
+		var rec asnRecord
+		if err := r.asn.Lookup(ip, &rec); err == nil {
+			info.ASN = rec.Number
+			info.Org = rec.Org
+		}
+	}
+	return info
+}
+
+// locateServer records the GeoIP data of the node's server unless it is already
+// known. resolved caches host lookups across nodes.
+func (r *geoIPReader) locateServer(node *types.Proxy, resolved map[string]net.IP) {
+	if node.ServerGeo != (types.GeoInfo{}) {
+		return
+	}
+	server := strings.ToLower(node.Server)
+	ip, ok := resolved[server]
+	if !ok {
+		ip = net.ParseIP(server)
+		if ip == nil && server != "" {
+			if ips, err := net.LookupIP(server); err == nil && len(ips) > 0 {
+				ip = ips[0]
+			}
+		}
+		resolved[server] = ip
+	}
+	if ip != nil {
+		node.ServerGeo = r.lookup(ip)
+	}
+}
+
+// locateServers records the country, ASN and organisation of every node's
+// server, so that parse-time filters can use them
+func locateServers(cfg types.Config, nodes []types.Proxy, logger *log.Logger) []types.Proxy {
+	reader := openGeoIP(cfg, logger)
+	if reader == nil {
+		return nodes
+	}
+	defer reader.Close()
+
+	resolved := make(map[string]net.IP)
+	located := 0
+	for i := range nodes {
+		reader.locateServer(&nodes[i], resolved)
+		if nodes[i].ServerGeo.Country != "" {
+			located++
+		}
+	}
+	logger.Printf("GeoIP - Located %d/%d servers", located, len(nodes))
+	return nodes
+}
+
+// enrichGeoIP records the country, ASN and organisation of every node's server
+// that locateServers has not seen and, when tested, its egress IP. Nodes are
+// returned unchanged if no database is configured.
+func enrichGeoIP(cfg types.Config, nodes []types.Proxy, logger *log.Logger) []types.Proxy {
+	reader := openGeoIP(cfg, logger)
+	if reader == nil {
+		return nodes
+	}
+	defer reader.Close()
+
+	resolved := make(map[string]net.IP)
+	enriched := 0
+	for i := range nodes {
+		node := &nodes[i]
+		reader.locateServer(node, resolved)
+		if egress := net.ParseIP(node.EgressIP); egress != nil {
+			node.EgressGeo = reader.lookup(egress)
+		}
+		if node.Geo().Country != "" {
+			enriched++
+		}
+	}
+	logger.Printf("GeoIP - Located %d/%d nodes", enriched, len(nodes))
+	return nodes
+}
+
+// countryFlag turns a two-letter country code into its flag emoji
+func countryFlag(code string) string {
+	if len(code) != 2 {
+		return ""
+	}
+	code = strings.ToUpper(code)
+	return string([]rune{rune(code[0]) - 'A' + 0x1F1E6, rune(code[1]) - 'A' + 0x1F1E6})
+}
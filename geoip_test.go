@@ -0,0 +1,288 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"subs-check-custom/types"
+)
+
+// mmdbNode is a node of the search tree built by writeTestMMDB. Each side
+// holds either a child node or a data offset plus one.
+type mmdbNode struct {
+	child [2]*mmdbNode
+	data  [2]int
+	index int
+}
+
+// writeTestMMDB writes a small IPv4 MaxMind DB mapping each CIDR to its record
+func writeTestMMDB(t *testing.T, records map[string]map[string]any) string {
+	t.Helper()
+	var data bytes.Buffer
+	root := &mmdbNode{}
+	nodes := []*mmdbNode{root}
+	cidrs := make([]string, 0, len(records))
+	for cidr := range records {
+		cidrs = append(cidrs, cidr)
+	}
+	sort.Strings(cidrs)
+	for _, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ones, _ := network.Mask.Size()
+		offset := data.Len()
+		encodeMMDB(&data, records[cidr])
+
+		node, ip := root, network.IP.To4()
+		for i := 0; i < ones; i++ {
+			bit := ip[i/8] >> (7 - i%8) & 1
+			if i == ones-1 {
+				node.data[bit] = offset + 1
+				break
+			}
+			if node.child[bit] == nil {
+				node.child[bit] = &mmdbNode{index: len(nodes)}
+				nodes = append(nodes, node.child[bit])
+			}
+			node = node.child[bit]
+		}
+	}
+
+	var db bytes.Buffer
+	count := len(nodes)
+	for _, node := range nodes {
+		for side := 0; side < 2; side++ {
+			record := count // Empty
+			if node.child[side] != nil {
+				record = node.child[side].index
+			} else if node.data[side] != 0 {
+				record = count + 16 + node.data[side] - 1
+			}
+			db.Write([]byte{byte(record >> 16), byte(record >> 8), byte(record)})
+		}
+	}
+	db.Write(make([]byte, 16))
+	db.Write(data.Bytes())
+	db.WriteString("\xAB\xCD\xEFMaxMind.com")
+	encodeMMDB(&db, map[string]any{
+		"node_count":                  uint32(count),
+		"record_size":                 uint16(24),
+		"ip_version":                  uint16(4),
+		"database_type":               "Test",
+		"languages":                   []any{"en"},
+		"binary_format_major_version": uint16(2),
+		"binary_format_minor_version": uint16(0),
+		"build_epoch":                 uint64(0),
+		"description":                 map[string]any{"en": "test"},
+	})
+
+	path := filepath.Join(t.TempDir(), "test.mmdb")
+	if err := os.WriteFile(path, db.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// encodeMMDB appends v in the MaxMind DB data section format
+func encodeMMDB(b *bytes.Buffer, v any) {
+	control := func(typ, size int) {
+		first, extra := byte(size), []byte(nil)
+		if size >= 29 { // Sizes up to 284 take one more byte, which is all these fixtures need
+			first, extra = 29, []byte{byte(size - 29)}
+		}
+		if typ <= 7 {
+			first |= byte(typ << 5)
+		}
+		b.WriteByte(first)
+		if typ > 7 {
+			b.WriteByte(byte(typ - 7))
+		}
+		b.Write(extra)
+	}
+	uintBytes := func(n uint64, max int) []byte {
+		buf := make([]byte, 8)
+		binary.BigEndian.PutUint64(buf, n)
+		buf = buf[8-max:]
+		for len(buf) > 0 && buf[0] == 0 {
+			buf = buf[1:]
+		}
+		return buf
+	}
+
+	switch v := v.(type) {
+	case string:
+		control(2, len(v))
+		b.WriteString(v)
+	case uint16:
+		n := uintBytes(uint64(v), 2)
+		control(5, len(n))
+		b.Write(n)
+	case uint32:
+		n := uintBytes(uint64(v), 4)
+		control(6, len(n))
+		b.Write(n)
+	case uint64:
+		n := uintBytes(v, 8)
+		control(9, len(n))
+		b.Write(n)
+	case map[string]any:
+		control(7, len(v))
+		for key, value := range v {
+			encodeMMDB(b, key)
+			encodeMMDB(b, value)
+		}
+	case []any:
+		control(11, len(v))
+		for _, value := range v {
+			encodeMMDB(b, value)
+		}
+	default:
+		panic("unsupported mmdb value")
+	}
+}
+
+func testGeoIPConfig(t *testing.T) types.Config {
+	country := func(code string) map[string]any { return map[string]any{"iso_code": code} }
+	return types.Config{
+		GeoIPCountryDB: writeTestMMDB(t, map[string]map[string]any{
+			"1.1.1.0/24":     {"country": country("HK"), "registered_country": country("AU")},
+			"8.8.0.0/16":     {"registered_country": country("US")},
+			"203.0.113.7/32": {"country": country("JP")},
+		}),
+		GeoIPASNDB: writeTestMMDB(t, map[string]map[string]any{
+			"1.1.1.0/24": {"autonomous_system_number": uint32(13335), "autonomous_system_organization": "Cloudflare"},
+			"8.0.0.0/8":  {"autonomous_system_number": uint32(15169), "autonomous_system_organization": "Google"},
+		}),
+	}
+}
+
+func TestGeoIPLookup(t *testing.T) {
+	var out bytes.Buffer
+	logger := log.New(&out, "", 0)
+	reader := openGeoIP(testGeoIPConfig(t), logger)
+	if reader == nil {
+		t.Fatalf("openGeoIP failed: %s", out.String())
+	}
+	defer reader.Close()
+
+	tests := []struct {
+		ip   string
+		want types.GeoInfo
+	}{
+		{"1.1.1.1", types.GeoInfo{Country: "HK", ASN: 13335, Org: "Cloudflare"}},
+		{"8.8.8.8", types.GeoInfo{Country: "US", ASN: 15169, Org: "Google"}}, // Registered country only
+		{"8.9.9.9", types.GeoInfo{ASN: 15169, Org: "Google"}},
+		{"203.0.113.7", types.GeoInfo{Country: "JP"}},
+		{"203.0.113.8", types.GeoInfo{}},
+		{"2606:4700::1111", types.GeoInfo{}}, // IPv6 in IPv4 databases
+	}
+	for _, tt := range tests {
+		if got := reader.lookup(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("lookup(%s) = %+v, want %+v", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestOpenGeoIP(t *testing.T) {
+	logger := log.New(io.Discard, "", 0)
+	if reader := openGeoIP(types.Config{}, logger); reader != nil {
+		t.Error("opened a reader without databases")
+	}
+
+	var out bytes.Buffer
+	cfg := types.Config{GeoIPCountryDB: filepath.Join(t.TempDir(), "missing.mmdb"), GeoIPASNDB: testGeoIPConfig(t).GeoIPASNDB}
+	reader := openGeoIP(cfg, log.New(&out, "", 0))
+	if reader == nil {
+		t.Fatal("a missing country database disabled the ASN one")
+	}
+	defer reader.Close()
+	if !strings.Contains(out.String(), "Failed to open country database") {
+		t.Errorf("log = %q, want the missing database reported", out.String())
+	}
+	if got := reader.lookup(net.ParseIP("1.1.1.1")); got != (types.GeoInfo{ASN: 13335, Org: "Cloudflare"}) {
+		t.Errorf("lookup = %+v, want the ASN only", got)
+	}
+}
+
+func TestLocateServers(t *testing.T) {
+	nodes := []types.Proxy{
+		{Name: "hk", Server: "1.1.1.1"},
+		{Name: "known", Server: "8.8.8.8", ServerGeo: types.GeoInfo{Country: "SG"}},
+		{Name: "us", Server: "8.8.4.4"},
+		{Name: "unknown", Server: "192.0.2.1"},
+	}
+	var out bytes.Buffer
+	nodes = locateServers(testGeoIPConfig(t), nodes, log.New(&out, "", 0))
+
+	want := []string{"HK", "SG", "US", ""}
+	for i, node := range nodes {
+		if node.ServerGeo.Country != want[i] {
+			t.Errorf("%s: country = %q, want %q", node.Name, node.ServerGeo.Country, want[i])
+		}
+	}
+	if nodes[2].ServerGeo.Org != "Google" {
+		t.Errorf("us: org = %q, want Google", nodes[2].ServerGeo.Org)
+	}
+	if !strings.Contains(out.String(), "Located 3/4 servers") {
+		t.Errorf("log = %q", out.String())
+	}
+
+	untouched := []types.Proxy{{Name: "hk", Server: "1.1.1.1"}}
+	if locateServers(types.Config{}, untouched, log.New(io.Discard, "", 0))[0].ServerGeo != (types.GeoInfo{}) {
+		t.Error("located a server without databases")
+	}
+}
+
+func TestEnrichGeoIP(t *testing.T) {
+	nodes := []types.Proxy{
+		{Name: "relay", Server: "8.8.8.8", EgressIP: "1.1.1.1"},
+		{Name: "direct", Server: "203.0.113.7"},
+		{Name: "located", Server: "8.8.8.8", ServerGeo: types.GeoInfo{Country: "SG"}, EgressIP: "203.0.113.7"},
+		{Name: "unknown-exit", Server: "8.8.8.8", EgressIP: "192.0.2.1"},
+	}
+	nodes = enrichGeoIP(testGeoIPConfig(t), nodes, log.New(io.Discard, "", 0))
+
+	tests := []struct {
+		server, egress, geo string
+	}{
+		{"US", "HK", "HK"},
+		{"JP", "", "JP"},
+		{"SG", "JP", "JP"},
+		{"US", "", "US"}, // An exit without data falls back to the server
+	}
+	for i, tt := range tests {
+		node := nodes[i]
+		if node.ServerGeo.Country != tt.server || node.EgressGeo.Country != tt.egress || node.Geo().Country != tt.geo {
+			t.Errorf("%s: server %q, egress %q, geo %q; want %q, %q, %q", node.Name,
+				node.ServerGeo.Country, node.EgressGeo.Country, node.Geo().Country, tt.server, tt.egress, tt.geo)
+		}
+	}
+	if nodes[0].Geo().Org != "Cloudflare" {
+		t.Errorf("relay: org = %q, want the exit's", nodes[0].Geo().Org)
+	}
+}
+
+func TestCountryFlag(t *testing.T) {
+	tests := map[string]string{
+		"HK":  "🇭🇰",
+		"us":  "🇺🇸",
+		"Jp":  "🇯🇵",
+		"":    "",
+		"USA": "",
+	}
+	for code, want := range tests {
+		if got := countryFlag(code); got != want {
+			t.Errorf("countryFlag(%q) = %q, want %q", code, got, want)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"log"
+	"math"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"subs-check-custom/types"
+)
+
+const historyBucket = "nodes"
+
+// historyRecord is the outcome of one node in one run
+type historyRecord struct {
+	Time    time.Time `json:"t"`
+	Passed  bool      `json:"ok"`
+	Stage   string    `json:"stage,omitempty"` // Stage of the failure
+	Class   string    `json:"class,omitempty"` // Failure class
+	Latency int64     `json:"latency,omitempty"`
+	Speed   float64   `json:"speed,omitempty"`
+}
+
+// updateHistory stores this run's outcome of every tested node in cfg.HistoryDB,
+// keyed by fingerprint, and attaches each node's summarised history. With
+// cfg.KeepProven, nodes that failed this run but have a proven record are kept.
+func updateHistory(cfg types.Config, candidates, tested []types.Proxy, outcomes map[string]*types.TestFailure, testLogger *log.Logger) []types.Proxy {
+	if cfg.HistoryDB == "" {
+		return tested
+	}
+	db, err := bolt.Open(cfg.HistoryDB, 0644, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		testLogger.Printf("History - Failed to open %s: %v", cfg.HistoryDB, err)
+		return tested
+	}
+	defer db.Close()
+
+	now := time.Now()
+	cutoff := now.AddDate(0, 0, -cfg.HistoryWindow)
+	testedByKey := make(map[string]types.Proxy, len(tested))
+	for _, node := range tested {
+		testedByKey[node.Fingerprint()] = node
+	}
+
+	stats := make(map[string]types.NodeStats)
+	err = db.Update(func(tx *bolt.Tx) error {
+		root, err := tx.CreateBucketIfNotExists([]byte(historyBucket))
+		if err != nil {
+			return err
+		}
+		for key, failure := range outcomes {
+			record := historyRecord{Time: now, Passed: failure == nil}
+			if failure != nil {
+				record.Stage = failure.Stage
+				record.Class = failure.Class
+			}
+			if node, ok := testedByKey[key]; ok {
+				record.Latency = node.Latency
+				record.Speed = node.Speed
+			}
+			records, err := appendHistory(root, key, record, cutoff, cfg.HistoryWindow > 0)
+			if err != nil {
+				return err
+			}
+			stats[key] = summarizeHistory(records)
+		}
+		return nil
+	})
+	if err != nil {
+		testLogger.Printf("History - Failed to update %s: %v", cfg.HistoryDB, err)
+		return tested
+	}
+
+	result := make([]types.Proxy, 0, len(tested))
+	for _, node := range tested {
+		if s, ok := stats[node.Fingerprint()]; ok {
+			node.History = &s
+		}
+		result = append(result, node)
+	}
+	if cfg.KeepProven {
+		for _, node := range candidates {
+			key := node.Fingerprint()
+			s, ok := stats[key]
+			if _, kept := testedByKey[key]; kept || !ok || outcomes[key] == nil {
+				continue
+			}
+			if s.Runs >= cfg.ProvenMinRuns && s.Uptime >= cfg.ProvenUptime {
+				node.History = &s
+				node.Failure = outcomes[key]
+				testLogger.Printf("History - Keeping proven node %s despite %s failure in %s (uptime %.1f%% over %d runs)", node.Name, node.Failure.Class, node.Failure.Stage, s.Uptime, s.Runs)
+				result = append(result, node)
+			}
+		}
+	}
+	testLogger.Printf("History - Recorded %d nodes in %s", len(outcomes), cfg.HistoryDB)
+	return result
+}
+
+// appendHistory adds record to the node's bucket, drops records older than
+// cutoff when prune is set and returns the remaining records oldest first
+func appendHistory(root *bolt.Bucket, key string, record historyRecord, cutoff time.Time, prune bool) ([]historyRecord, error) {
+	bucket, err := root.CreateBucketIfNotExists([]byte(key))
+	if err != nil {
+		return nil, err
+	}
+	value, err := json.Marshal(record)
+	if err != nil {
+		return nil, err
+	}
+	if err := bucket.Put(historyKey(record.Time), value); err != nil {
+		return nil, err
+	}
+
+	var records []historyRecord
+	var expired [][]byte
+	c := bucket.Cursor()
+	for k, v := c.First(); k != nil; k, v = c.Next() {
+		var r historyRecord
+		if err := json.Unmarshal(v, &r); err != nil {
+			continue
+		}
+		if prune && r.Time.Before(cutoff) {
+			expired = append(expired, append([]byte(nil), k...))
+			continue
+		}
+		records = append(records, r)
+	}
+	for _, k := range expired {
+		if err := bucket.Delete(k); err != nil {
+			return nil, err
+		}
+	}
+	return records, nil
+}
+
+// historyKey orders records by time within a node's bucket
+func historyKey(t time.Time) []byte {
+	return binary.BigEndian.AppendUint64(nil, uint64(t.UnixNano()))
+}
+
+// summarizeHistory computes a node's statistics from its records, oldest first.
+// Stability is the uptime reduced by up to half for a node that flips between
+// passing and failing on every run.
+func summarizeHistory(records []historyRecord) types.NodeStats {
+	stats := types.NodeStats{Runs: len(records)}
+	if len(records) == 0 {
+		return stats
+	}
+
+	passed, flips := 0, 0
+	var latencies []float64
+	var times, speeds []float64
+	for i, r := range records {
+		if r.Passed {
+			passed++
+			if r.Latency > 0 {
+				latencies = append(latencies, float64(r.Latency))
+			}
+			if r.Speed > 0 {
+				times = append(times, r.Time.Sub(records[0].Time).Hours()/24)
+				speeds = append(speeds, r.Speed)
+			}
+		}
+		if i > 0 && r.Passed != records[i-1].Passed {
+			flips++
+		}
+	}
+	stats.Uptime = float64(passed) * 100 / float64(len(records))
+
+	if len(latencies) > 0 {
+		sort.Float64s(latencies)
+		mid := len(latencies) / 2
+		stats.MedianLatency = latencies[mid]
+		if len(latencies)%2 == 0 {
+			stats.MedianLatency = (latencies[mid-1] + latencies[mid]) / 2
+		}
+	}
+	stats.SpeedTrend = slope(times, speeds)
+
+	flapRate := 0.0
+	if len(records) > 1 {
+		flapRate = float64(flips) / float64(len(records)-1)
+	}
+	stats.Stability = stats.Uptime * (1 - flapRate/2)
+	return stats
+}
+
+// slope returns the least-squares slope of ys over xs, or 0 with fewer than two distinct xs
+func slope(xs, ys []float64) float64 {
+	n := float64(len(xs))
+	if n < 2 {
+		return 0
+	}
+	var sumX, sumY, sumXY, sumXX float64
+	for i := range xs {
+		sumX += xs[i]
+		sumY += ys[i]
+		sumXY += xs[i] * ys[i]
+		sumXX += xs[i] * xs[i]
+	}
+	denominator := n*sumXX - sumX*sumX
+	if math.Abs(denominator) < 1e-12 {
+		return 0
+	}
+	return (n*sumXY - sumX*sumY) / denominator
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"io"
+	"log"
+	"math"
+	"path/filepath"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"subs-check-custom/types"
+)
+
+func TestSummarizeHistory(t *testing.T) {
+	day := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	run := func(days int, passed bool, latency int64, speed float64) historyRecord {
+		return historyRecord{Time: day.AddDate(0, 0, days), Passed: passed, Latency: latency, Speed: speed}
+	}
+
+	tests := []struct {
+		name    string
+		records []historyRecord
+		want    types.NodeStats
+		trend   int // Sign of SpeedTrend
+	}{
+		{"empty", nil, types.NodeStats{}, 0},
+		{"single pass", []historyRecord{run(0, true, 120, 800)},
+			types.NodeStats{Runs: 1, Uptime: 100, MedianLatency: 120, Stability: 100}, 0},
+		{"single failure", []historyRecord{run(0, false, 0, 0)},
+			types.NodeStats{Runs: 1}, 0},
+		{"steady", []historyRecord{run(0, true, 100, 500), run(1, true, 300, 500), run(2, true, 200, 500)},
+			types.NodeStats{Runs: 3, Uptime: 100, MedianLatency: 200, Stability: 100}, 0},
+		// Failed runs count against uptime but not the median latency
+		{"even median", []historyRecord{run(0, true, 100, 0), run(1, false, 0, 0), run(2, true, 300, 0), run(3, true, 200, 0), run(4, true, 400, 0)},
+			types.NodeStats{Runs: 5, Uptime: 80, MedianLatency: 250, Stability: 80 * (1 - 2.0/4/2)}, 0},
+		// Every run flips, so stability is half the uptime
+		{"flapping", []historyRecord{run(0, true, 100, 0), run(1, false, 0, 0), run(2, true, 100, 0), run(3, false, 0, 0)},
+			types.NodeStats{Runs: 4, Uptime: 50, MedianLatency: 100, Stability: 25}, 0},
+		// The same uptime with one change is more stable
+		{"went down", []historyRecord{run(0, true, 100, 0), run(1, true, 100, 0), run(2, false, 0, 0), run(3, false, 0, 0)},
+			types.NodeStats{Runs: 4, Uptime: 50, MedianLatency: 100, Stability: 50 * (1 - 1.0/3/2)}, 0},
+		{"speeding up", []historyRecord{run(0, true, 100, 400), run(1, true, 100, 500), run(3, false, 0, 0), run(4, true, 100, 800)},
+			types.NodeStats{Runs: 4, Uptime: 75, MedianLatency: 100, Stability: 75 * (1 - 2.0/3/2)}, 1},
+		{"slowing down", []historyRecord{run(0, true, 100, 900), run(2, true, 100, 700), run(4, true, 100, 500)},
+			types.NodeStats{Runs: 3, Uptime: 100, MedianLatency: 100, Stability: 100}, -1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := summarizeHistory(tt.records)
+			trend := got.SpeedTrend
+			got.SpeedTrend = 0
+			if got.Runs != tt.want.Runs || !near(got.Uptime, tt.want.Uptime) || !near(got.MedianLatency, tt.want.MedianLatency) ||
+				!near(got.Stability, tt.want.Stability) {
+				t.Errorf("summarizeHistory = %+v, want %+v", got, tt.want)
+			}
+			if sign := int(math.Copysign(1, trend)); trend == 0 && tt.trend != 0 || trend != 0 && sign != tt.trend {
+				t.Errorf("speed trend = %v, want sign %d", trend, tt.trend)
+			}
+		})
+	}
+}
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestSlope(t *testing.T) {
+	tests := []struct {
+		name   string
+		xs, ys []float64
+		want   float64
+	}{
+		{"line", []float64{0, 1, 2, 3}, []float64{1, 3, 5, 7}, 2},
+		{"falling", []float64{0, 2, 4}, []float64{900, 700, 500}, -100},
+		{"flat", []float64{0, 1, 2}, []float64{5, 5, 5}, 0},
+		{"noisy", []float64{0, 1, 2, 3}, []float64{1, 3, 2, 4}, 0.8},
+		{"one point", []float64{1}, []float64{5}, 0},
+		{"same x", []float64{2, 2, 2}, []float64{1, 5, 9}, 0},
+		{"empty", nil, nil, 0},
+	}
+	for _, tt := range tests {
+		if got := slope(tt.xs, tt.ys); !near(got, tt.want) {
+			t.Errorf("%s: slope = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// seedHistory stores records for the node in db before updateHistory runs
+func seedHistory(t *testing.T, path string, node types.Proxy, records ...historyRecord) {
+	t.Helper()
+	db, err := bolt.Open(path, 0644, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	err = db.Update(func(tx *bolt.Tx) error {
+		root, err := tx.CreateBucketIfNotExists([]byte(historyBucket))
+		if err != nil {
+			return err
+		}
+		for _, r := range records {
+			if _, err := appendHistory(root, node.Fingerprint(), r, time.Time{}, false); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// storedRuns returns how many records db holds for the node
+func storedRuns(t *testing.T, path string, node types.Proxy) int {
+	t.Helper()
+	db, err := bolt.Open(path, 0644, &bolt.Options{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	runs := 0
+	db.View(func(tx *bolt.Tx) error {
+		if bucket := tx.Bucket([]byte(historyBucket)).Bucket([]byte(node.Fingerprint())); bucket != nil {
+			runs = bucket.Stats().KeyN
+		}
+		return nil
+	})
+	return runs
+}
+
+func TestUpdateHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "history.db")
+	cfg := types.Config{HistoryDB: path, HistoryWindow: 7, KeepProven: true, ProvenUptime: 75, ProvenMinRuns: 4}
+	logger := log.New(io.Discard, "", 0)
+
+	fast := types.Proxy{Name: "fast", Type: "trojan", Server: "a.example.com", Port: 443, Password: "a"}
+	proven := types.Proxy{Name: "proven", Type: "trojan", Server: "b.example.com", Port: 443, Password: "b"}
+	flaky := types.Proxy{Name: "flaky", Type: "trojan", Server: "c.example.com", Port: 443, Password: "c"}
+	stale := types.Proxy{Name: "stale", Type: "trojan", Server: "d.example.com", Port: 443, Password: "d"}
+
+	now := time.Now()
+	ago := func(days int, passed bool) historyRecord {
+		return historyRecord{Time: now.AddDate(0, 0, -days), Passed: passed, Latency: 100, Speed: 500}
+	}
+	seedHistory(t, path, fast, ago(30, false), ago(20, false), ago(2, true)) // Two runs outside the window
+	seedHistory(t, path, proven, ago(4, true), ago(3, true), ago(2, true), ago(1, true))
+	seedHistory(t, path, flaky, ago(3, true), ago(2, false), ago(1, true))
+	seedHistory(t, path, stale, ago(40, true), ago(30, true), ago(20, true), ago(10, true))
+
+	timeout := &types.TestFailure{Stage: "TCP test", Class: "timeout"}
+	outcomes := map[string]*types.TestFailure{
+		fast.Fingerprint():   nil,
+		proven.Fingerprint(): timeout,
+		flaky.Fingerprint():  timeout,
+		stale.Fingerprint():  timeout,
+	}
+	tested := fast
+	tested.Latency, tested.Speed = 80, 900
+	result := updateHistory(cfg, []types.Proxy{fast, proven, flaky, stale}, []types.Proxy{tested}, outcomes, logger)
+
+	if len(result) != 2 || result[0].Name != "fast" || result[1].Name != "proven" {
+		t.Fatalf("kept %v, want fast and the proven node", result)
+	}
+	if h := result[0].History; h == nil || h.Runs != 2 || h.Uptime != 100 || h.MedianLatency != 90 || h.SpeedTrend <= 0 {
+		t.Errorf("fast history = %+v, want 2 passed runs in the window with a rising speed", h)
+	}
+	if h := result[1].History; h == nil || h.Runs != 5 || h.Uptime != 80 {
+		t.Errorf("proven history = %+v, want 4 of 5 runs passed", h)
+	}
+	if result[1].Failure != timeout {
+		t.Errorf("proven node failure = %v, want this run's", result[1].Failure)
+	}
+
+	// Records outside the window are deleted from the file
+	for _, stored := range []struct {
+		node types.Proxy
+		runs int
+	}{{fast, 2}, {proven, 5}, {flaky, 4}, {stale, 1}} {
+		if runs := storedRuns(t, path, stored.node); runs != stored.runs {
+			t.Errorf("%s: %d stored runs, want %d", stored.node.Name, runs, stored.runs)
+		}
+	}
+
+	// Without keep-proven the failed nodes stay out, and a window of 0 keeps everything
+	cfg.KeepProven, cfg.HistoryWindow = false, 0
+	seedHistory(t, path, fast, ago(60, true))
+	result = updateHistory(cfg, []types.Proxy{fast, proven}, []types.Proxy{tested}, outcomes, logger)
+	if len(result) != 1 || result[0].History.Runs != 4 {
+		t.Errorf("kept %v, want fast with 4 runs", result)
+	}
+}
+
+func TestUpdateHistoryDisabled(t *testing.T) {
+	tested := []types.Proxy{{Name: "a"}}
+	result := updateHistory(types.Config{}, tested, tested, map[string]*types.TestFailure{tested[0].Fingerprint(): nil}, log.New(io.Discard, "", 0))
+	if len(result) != 1 || result[0].History != nil {
+		t.Errorf("updateHistory without a db = %+v", result)
+	}
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptrace"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"subs-check-custom/types"
+)
+
+const defaultLatencyURL = "https://www.gstatic.com/generate_204"
+
+// latencyTest probes every node with a direct TCP connect, a direct TLS handshake
+// for TLS nodes and a generate_204 request through the proxy, cfg.LatencyProbes
+// times each. Nodes exceeding a bound in cfg.LatencyMax are dropped.
+func latencyTest(cfg types.Config, nodes []types.Proxy, sched *testScheduler, testLogger *log.Logger, dropFailed bool) []types.Proxy {
+	runs := cfg.LatencyProbes
+	if runs <= 0 {
+		return nodes
+	}
+	latencyURL := cfg.LatencyURL
+	if latencyURL == "" {
+		latencyURL = defaultLatencyURL
+	}
+	for metric := range cfg.LatencyMax {
+		if _, _, err := latencyMetric(types.Proxy{}, metric); err != nil {
+			testLogger.Printf("Latency - Ignoring latency-max entry: %v", err)
+		}
+	}
+	timeout := time.Duration(cfg.Timeout) * time.Millisecond
+
+	results, counts := sched.run("Latency test", nodes, func(nodeIndex int, n *types.Proxy, proxyAddr string) error {
+		address := net.JoinHostPort(n.Server, strconv.Itoa(n.Port))
+		n.TCPPing = probeLatency(runs, func() (time.Duration, error) {
+			return tcpConnectTime(address, timeout)
+		})
+		if n.TLS {
+			serverName := n.SNI
+			if serverName == "" {
+				serverName = n.WSOpts["host"]
+			}
+			if serverName == "" {
+				serverName = n.Server
+			}
+			n.TLSPing = probeLatency(runs, func() (time.Duration, error) {
+				return tlsHandshakeTime(address, serverName, timeout)
+			})
+		}
+
+		client, err := newProxyClient(proxyAddr, timeout)
+		if err != nil {
+			testLogger.Printf("Node %d: Fail - Failed to create SOCKS5 dialer for %s (%v)", nodeIndex, proxyAddr, err)
+			return err
+		}
+		// Every run opens a fresh connection so it covers the full path through the node
+		client.Transport.(*http.Transport).DisableKeepAlives = true
+		// Single lost probes count as loss; only a round in which every probe fails is retried
+		err = sched.retry.do(func(attempt int) error {
+			var lastErr error
+			n.HTTPPing = probeLatency(runs, func() (time.Duration, error) {
+				d, err := firstByteTime(client, latencyURL)
+				if err != nil {
+					lastErr = err
+				}
+				return d, err
+			})
+			if n.HTTPPing.Loss == 100 {
+				return lastErr
+			}
+			return nil
+		})
+
+		testLogger.Printf("Node %d: Latency - tcp %s, tls %s, http %s (%s)", nodeIndex, formatLatency(n.TCPPing), formatLatency(n.TLSPing), formatLatency(n.HTTPPing), n.Name)
+		if err != nil {
+			testLogger.Printf("Node %d: Fail - Every HTTP probe failed for %s (%v)", nodeIndex, n.Name, err)
+			return err
+		}
+		n.Latency = int64(math.Round(n.HTTPPing.Median))
+		for metric, limit := range cfg.LatencyMax {
+			value, ok, err := latencyMetric(*n, metric)
+			if err != nil || !ok {
+				continue
+			}
+			if value > limit {
+				testLogger.Printf("Node %d: Fail - %s %.1f exceeds %.1f for %s", nodeIndex, metric, value, limit, n.Name)
+				return fmt.Errorf("%w: %s %.1f > %.1f", errThreshold, metric, value, limit)
+			}
+		}
+		return nil
+	})
+
+	testLogger.Printf("Latency Test - Total nodes: %d, Successful: %d, Failed: %d, Skipped: %d, Failures: %s", counts.Total, counts.Passed, counts.Failed, counts.Skipped, formatFailures(counts.Failures))
+	return keepResults(nodes, results, dropFailed)
+}
+
+// probeLatency runs probe the given number of times and summarises the successful runs
+func probeLatency(runs int, probe func() (time.Duration, error)) types.LatencyStats {
+	samples := make([]float64, 0, runs)
+	for i := 0; i < runs; i++ {
+		d, err := probe()
+		if err != nil {
+			continue
+		}
+		samples = append(samples, float64(d.Microseconds())/1000)
+	}
+	return summarizeLatency(runs, samples)
+}
+
+// summarizeLatency computes the statistics for samples, in the order they were
+// taken, out of runs attempts. Jitter is the mean difference between consecutive samples.
+func summarizeLatency(runs int, samples []float64) types.LatencyStats {
+	stats := types.LatencyStats{Runs: runs}
+	if runs == 0 {
+		return stats
+	}
+	stats.Loss = float64(runs-len(samples)) * 100 / float64(runs)
+	if len(samples) == 0 {
+		return stats
+	}
+
+	for i := 1; i < len(samples); i++ {
+		stats.Jitter += math.Abs(samples[i] - samples[i-1])
+	}
+	if len(samples) > 1 {
+		stats.Jitter /= float64(len(samples) - 1)
+	}
+
+	sorted := append([]float64(nil), samples...)
+	sort.Float64s(sorted)
+	stats.Min = sorted[0]
+	if mid := len(sorted) / 2; len(sorted)%2 == 1 {
+		stats.Median = sorted[mid]
+	} else {
+		stats.Median = (sorted[mid-1] + sorted[mid]) / 2
+	}
+	// Nearest-rank percentile
+	rank := int(math.Ceil(0.95*float64(len(sorted)))) - 1
+	stats.P95 = sorted[rank]
+	return stats
+}
+
+// tcpConnectTime measures a direct TCP connect to address
+func tcpConnectTime(address string, timeout time.Duration) (time.Duration, error) {
+	start := time.Now()
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return 0, err
+	}
+	elapsed := time.Since(start)
+	conn.Close()
+	return elapsed, nil
+}
+
+// tlsHandshakeTime measures the TLS handshake with address after the TCP connect.
+// Certificates are not verified; only the handshake time matters here.
+func tlsHandshakeTime(address, serverName string, timeout time.Duration) (time.Duration, error) {
+	conn, err := net.DialTimeout("tcp", address, timeout)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	tlsConn := tls.Client(conn, &tls.Config{ServerName: serverName, InsecureSkipVerify: true})
+	start := time.Now()
+	if err := tlsConn.Handshake(); err != nil {
+		return 0, err
+	}
+	return time.Since(start), nil
+}
+
+// firstByteTime requests url through client and returns the time until the first
+// response byte. 204 and 200 are both accepted.
+func firstByteTime(client *http.Client, url string) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), client.Timeout)
+	defer cancel()
+
+	var firstByte time.Time
+	trace := &httptrace.ClientTrace{GotFirstResponseByte: func() { firstByte = time.Now() }}
+	req, err := http.NewRequestWithContext(httptrace.WithClientTrace(ctx, trace), "GET", url, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return 0, statusError{resp.StatusCode}
+	}
+	if firstByte.IsZero() {
+		firstByte = time.Now()
+	}
+	return firstByte.Sub(start), nil
+}
+
+// latencyMetric looks up a metric named <probe>-<stat>, where probe is tcp, tls
+// or http and stat is min, median, p95, jitter or loss. ok is false when the
+// probe did not run for the node.
+func latencyMetric(node types.Proxy, metric string) (value float64, ok bool, err error) {
+	probe, stat, found := strings.Cut(strings.ToLower(metric), "-")
+	if !found {
+		return 0, false, fmt.Errorf("unknown latency metric %q", metric)
+	}
+	var stats types.LatencyStats
+	switch probe {
+	case "tcp":
+		stats = node.TCPPing
+	case "tls":
+		stats = node.TLSPing
+	case "http":
+		stats = node.HTTPPing
+	default:
+		return 0, false, fmt.Errorf("unknown latency probe %q in %q", probe, metric)
+	}
+	switch stat {
+	case "min":
+		value = stats.Min
+	case "median":
+		value = stats.Median
+	case "p95":
+		value = stats.P95
+	case "jitter":
+		value = stats.Jitter
+	case "loss":
+		value = stats.Loss
+	default:
+		return 0, false, fmt.Errorf("unknown latency statistic %q in %q", stat, metric)
+	}
+	return value, stats.Runs > 0, nil
+}
+
+// formatLatency renders stats for the test log
+func formatLatency(stats types.LatencyStats) string {
+	if stats.Runs == 0 {
+		return "n/a"
+	}
+	return fmt.Sprintf("min %.1f/median %.1f/p95 %.1f ms, jitter %.1f ms, loss %.0f%%", stats.Min, stats.Median, stats.P95, stats.Jitter, stats.Loss)
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"math"
+	"testing"
+
+	"subs-check-custom/types"
+)
+
+func TestSummarizeLatency(t *testing.T) {
+	var ramp []float64
+	for i := 1; i <= 20; i++ {
+		ramp = append(ramp, float64(i))
+	}
+
+	tests := []struct {
+		name    string
+		runs    int
+		samples []float64
+		want    types.LatencyStats
+	}{
+		{"not run", 0, nil, types.LatencyStats{}},
+		{"all lost", 3, nil, types.LatencyStats{Runs: 3, Loss: 100}},
+		{"single sample", 1, []float64{42}, types.LatencyStats{Runs: 1, Min: 42, Median: 42, P95: 42}},
+		{"single sample of four", 4, []float64{50}, types.LatencyStats{Runs: 4, Min: 50, Median: 50, P95: 50, Loss: 75}},
+		// Jitter follows the order the samples were taken in, the rest the sorted values
+		{"odd", 5, []float64{30, 10, 20, 50, 40}, types.LatencyStats{Runs: 5, Min: 10, Median: 30, P95: 50, Jitter: 17.5}},
+		{"even with loss", 5, []float64{100, 140, 120, 110}, types.LatencyStats{Runs: 5, Min: 100, Median: 115, P95: 140, Jitter: 70.0 / 3, Loss: 20}},
+		{"nearest rank", 20, ramp, types.LatencyStats{Runs: 20, Min: 1, Median: 10.5, P95: 19, Jitter: 1}},
+		{"steady", 3, []float64{80, 80, 80}, types.LatencyStats{Runs: 3, Min: 80, Median: 80, P95: 80}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			samples := append([]float64(nil), tt.samples...)
+			got := summarizeLatency(tt.runs, samples)
+			if got.Runs != tt.want.Runs || got.Min != tt.want.Min || got.Median != tt.want.Median || got.P95 != tt.want.P95 ||
+				math.Abs(got.Jitter-tt.want.Jitter) > 1e-9 || got.Loss != tt.want.Loss {
+				t.Errorf("summarizeLatency = %+v, want %+v", got, tt.want)
+			}
+			for i := range samples {
+				if samples[i] != tt.samples[i] {
+					t.Fatalf("samples reordered to %v", samples)
+				}
+			}
+		})
+	}
+}
+
+func TestLatencyMetric(t *testing.T) {
+	node := types.Proxy{
+		TCPPing:  types.LatencyStats{Runs: 3, Min: 20, Median: 25, P95: 40, Jitter: 5, Loss: 0},
+		HTTPPing: types.LatencyStats{Runs: 3, Loss: 100},
+	}
+
+	tests := []struct {
+		metric string
+		value  float64
+		ok     bool
+	}{
+		{"tcp-min", 20, true},
+		{"tcp-median", 25, true},
+		{"TCP-P95", 40, true},
+		{"tcp-jitter", 5, true},
+		{"tcp-loss", 0, true},
+		{"http-loss", 100, true},
+		{"http-median", 0, true}, // Every run lost
+		{"tls-median", 0, false}, // Not run
+	}
+	for _, tt := range tests {
+		value, ok, err := latencyMetric(node, tt.metric)
+		if err != nil || value != tt.value || ok != tt.ok {
+			t.Errorf("latencyMetric(%q) = %v, %v, %v; want %v, %v", tt.metric, value, ok, err, tt.value, tt.ok)
+		}
+	}
+
+	for _, metric := range []string{"latency", "udp-min", "http-max", "http-"} {
+		if _, _, err := latencyMetric(node, metric); err == nil {
+			t.Errorf("latencyMetric(%q) accepted an unknown metric", metric)
+		}
+	}
+}
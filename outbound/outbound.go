@@ -0,0 +1,234 @@
+// Package outbound turns parsed proxies into Xray outbound configurations
+package outbound
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/xtls/xray-core/core"
+	"github.com/xtls/xray-core/infra/conf"
+
+	"subs-check-custom/types"
+)
+
+// ssCiphers lists the Shadowsocks methods Xray can speak. Legacy stream ciphers
+// such as aes-256-cfb and rc4-md5 were dropped from Xray and are rejected here.
+var ssCiphers = map[string]bool{
+	"aes-128-gcm":                   true,
+	"aes-256-gcm":                   true,
+	"chacha20-poly1305":             true,
+	"chacha20-ietf-poly1305":        true,
+	"xchacha20-poly1305":            true,
+	"xchacha20-ietf-poly1305":       true,
+	"none":                          true,
+	"plain":                         true,
+	"2022-blake3-aes-128-gcm":       true,
+	"2022-blake3-aes-256-gcm":       true,
+	"2022-blake3-chacha20-poly1305": true,
+}
+
+// Supported reports whether Xray can build an outbound for the node
+func Supported(node types.Proxy) bool {
+	return unsupportedReason(node) == ""
+}
+
+// unsupportedReason explains why Xray cannot carry the node, or returns "" if it can
+func unsupportedReason(node types.Proxy) string {
+	switch node.Type {
+	case "vmess", "vless", "trojan":
+	case "ss":
+		if !ssCiphers[strings.ToLower(node.Cipher)] {
+			return fmt.Sprintf("shadowsocks cipher %s is not supported by Xray", node.Cipher)
+		}
+	default:
+		// ssr and hysteria2 have no Xray outbound
+		return fmt.Sprintf("%s nodes are not supported by Xray", node.Type)
+	}
+	switch network(node) {
+	case "tcp", "ws", "grpc", "httpupgrade", "xhttp", "splithttp", "kcp":
+		return ""
+	}
+	return fmt.Sprintf("%s transport is not supported by Xray", network(node))
+}
+
+// Config returns the Xray JSON outbound object for the node, as it would appear
+// in the "outbounds" array of an Xray config file
+func Config(node types.Proxy, tag string) (map[string]interface{}, error) {
+	if reason := unsupportedReason(node); reason != "" {
+		return nil, errors.New(reason)
+	}
+
+	var protocol string
+	var settings map[string]interface{}
+	switch node.Type {
+	case "vmess":
+		cipher := node.Cipher
+		if cipher == "" {
+			cipher = "auto"
+		}
+		protocol = "vmess"
+		settings = map[string]interface{}{
+			"vnext": []interface{}{map[string]interface{}{
+				"address": node.Server,
+				"port":    node.Port,
+				"users": []interface{}{map[string]interface{}{
+					"id":       node.UUID,
+					"security": cipher,
+				}},
+			}},
+		}
+	case "vless":
+		user := map[string]interface{}{
+			"id":         node.UUID,
+			"encryption": "none",
+		}
+		if node.Flow != "" {
+			user["flow"] = node.Flow
+		}
+		protocol = "vless"
+		settings = map[string]interface{}{
+			"vnext": []interface{}{map[string]interface{}{
+				"address": node.Server,
+				"port":    node.Port,
+				"users":   []interface{}{user},
+			}},
+		}
+	case "trojan":
+		protocol = "trojan"
+		settings = map[string]interface{}{
+			"servers": []interface{}{map[string]interface{}{
+				"address":  node.Server,
+				"port":     node.Port,
+				"password": node.Password,
+			}},
+		}
+	case "ss":
+		protocol = "shadowsocks"
+		settings = map[string]interface{}{
+			"servers": []interface{}{map[string]interface{}{
+				"address":  node.Server,
+				"port":     node.Port,
+				"method":   strings.ToLower(node.Cipher),
+				"password": node.Password,
+			}},
+		}
+	}
+
+	return map[string]interface{}{
+		"tag":            tag,
+		"protocol":       protocol,
+		"settings":       settings,
+		"streamSettings": streamSettings(node),
+	}, nil
+}
+
+// JSON returns the indented JSON form of Config
+func JSON(node types.Proxy, tag string) ([]byte, error) {
+	config, err := Config(node, tag)
+	if err != nil {
+		return nil, err
+	}
+	return json.MarshalIndent(config, "", "  ")
+}
+
+// Build returns the protobuf outbound handler config Xray's core and gRPC API expect
+func Build(node types.Proxy, tag string) (*core.OutboundHandlerConfig, error) {
+	data, err := JSON(node, tag)
+	if err != nil {
+		return nil, err
+	}
+	detour := &conf.OutboundDetourConfig{}
+	if err := json.Unmarshal(data, detour); err != nil {
+		return nil, fmt.Errorf("failed to load outbound JSON: %v", err)
+	}
+	handler, err := detour.Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build outbound: %v", err)
+	}
+	return handler, nil
+}
+
+// network returns the normalised transport name of the node
+func network(node types.Proxy) string {
+	switch n := strings.ToLower(node.Network); n {
+	case "", "tcp", "raw", "udp":
+		// Parsers mark hysteria2 as udp; it is rejected by type before this matters
+		return "tcp"
+	case "websocket":
+		return "ws"
+	case "mkcp":
+		return "kcp"
+	default:
+		return n
+	}
+}
+
+// streamSettings builds the transport and security part of the outbound
+func streamSettings(node types.Proxy) map[string]interface{} {
+	net := network(node)
+	stream := map[string]interface{}{
+		"network":  net,
+		"security": "none",
+	}
+
+	host := node.WSOpts["host"]
+	path := node.WSOpts["path"]
+	if path == "" {
+		path = node.Path
+	}
+	switch net {
+	case "ws":
+		ws := map[string]interface{}{"path": path}
+		if host != "" {
+			ws["host"] = host
+		}
+		stream["wsSettings"] = ws
+	case "httpupgrade":
+		stream["httpupgradeSettings"] = map[string]interface{}{"path": path, "host": host}
+	case "xhttp", "splithttp":
+		stream["network"] = "xhttp"
+		stream["xhttpSettings"] = map[string]interface{}{"path": path, "host": host}
+	case "grpc":
+		stream["grpcSettings"] = map[string]interface{}{"serviceName": node.GrpcOpts["grpc-service-name"]}
+	}
+
+	if !node.TLS {
+		return stream
+	}
+	serverName := node.SNI
+	if serverName == "" {
+		serverName = host
+	}
+	if serverName == "" {
+		serverName = node.Server
+	}
+	if node.RealityOpts["public-key"] != "" {
+		fingerprint := node.ClientFingerprint
+		if fingerprint == "" {
+			fingerprint = "chrome" // REALITY requires a uTLS fingerprint
+		}
+		stream["security"] = "reality"
+		stream["realitySettings"] = map[string]interface{}{
+			"serverName":  serverName,
+			"fingerprint": fingerprint,
+			"publicKey":   node.RealityOpts["public-key"],
+			"shortId":     node.RealityOpts["short-id"],
+		}
+		return stream
+	}
+	tls := map[string]interface{}{
+		"serverName":    serverName,
+		"allowInsecure": node.SkipCertVerify,
+	}
+	if node.ClientFingerprint != "" {
+		tls["fingerprint"] = node.ClientFingerprint
+	}
+	if len(node.ALPN) > 0 {
+		tls["alpn"] = node.ALPN
+	}
+	stream["security"] = "tls"
+	stream["tlsSettings"] = tls
+	return stream
+}
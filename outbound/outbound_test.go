@@ -0,0 +1,130 @@
+package outbound
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"subs-check-custom/types"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// goldenCases covers every protocol and transport Xray can carry
+var goldenCases = []struct {
+	name string
+	node types.Proxy
+}{
+	{"vmess_ws_tls", types.Proxy{
+		Name: "vmess", Type: "vmess", Server: "vmess.example.com", Port: 443,
+		UUID: "b831381d-6324-4d53-ad4f-8cda48b30811", Cipher: "auto",
+		Network: "ws", WSOpts: map[string]string{"path": "/ray", "host": "cdn.example.com"},
+		TLS: true, SNI: "cdn.example.com", ALPN: []string{"h2", "http/1.1"},
+	}},
+	{"vless_reality_vision", types.Proxy{
+		Name: "vless", Type: "vless", Server: "203.0.113.10", Port: 443,
+		UUID: "b831381d-6324-4d53-ad4f-8cda48b30811", Flow: "xtls-rprx-vision",
+		Network: "tcp", TLS: true, SNI: "www.microsoft.com",
+		RealityOpts: map[string]string{"public-key": "Z84J2IelR9ch3k8VtlVhhs5ycBUlXA7wHBWcBrjqnAw", "short-id": "6ba85179e30d4fc2"},
+	}},
+	{"vless_grpc", types.Proxy{
+		Name: "vless-grpc", Type: "vless", Server: "grpc.example.com", Port: 443,
+		UUID:    "b831381d-6324-4d53-ad4f-8cda48b30811",
+		Network: "grpc", GrpcOpts: map[string]string{"grpc-service-name": "tunnel"},
+		TLS: true, SNI: "grpc.example.com", ClientFingerprint: "firefox",
+	}},
+	{"trojan_ws", types.Proxy{
+		Name: "trojan", Type: "trojan", Server: "trojan.example.com", Port: 443,
+		Password: "secret", Network: "ws", WSOpts: map[string]string{"path": "/trojan"},
+		TLS: true, SNI: "trojan.example.com", SkipCertVerify: true,
+	}},
+	{"ss", types.Proxy{
+		Name: "ss", Type: "ss", Server: "198.51.100.4", Port: 8388,
+		Cipher: "AES-256-GCM", Password: "secret", Network: "tcp",
+	}},
+	{"vless_xhttp", types.Proxy{
+		Name: "vless-xhttp", Type: "vless", Server: "xhttp.example.com", Port: 443,
+		UUID:    "b831381d-6324-4d53-ad4f-8cda48b30811",
+		Network: "splithttp", WSOpts: map[string]string{"path": "/up", "host": "xhttp.example.com"},
+		TLS: true,
+	}},
+	{"vmess_kcp", types.Proxy{
+		Name: "vmess-kcp", Type: "vmess", Server: "198.51.100.5", Port: 10000,
+		UUID: "b831381d-6324-4d53-ad4f-8cda48b30811", Network: "mkcp",
+	}},
+}
+
+func TestJSONGolden(t *testing.T) {
+	for _, tc := range goldenCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := JSON(tc.node, "test_out_0")
+			if err != nil {
+				t.Fatalf("JSON() error: %v", err)
+			}
+			got = append(got, '\n')
+			path := filepath.Join("testdata", tc.name+".json")
+			if *update {
+				if err := os.WriteFile(path, got, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatalf("read golden file (run with -update to create it): %v", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("JSON() differs from %s\ngot:\n%s\nwant:\n%s", path, got, want)
+			}
+		})
+	}
+}
+
+func TestBuild(t *testing.T) {
+	for _, tc := range goldenCases {
+		t.Run(tc.name, func(t *testing.T) {
+			handler, err := Build(tc.node, "test_out_0")
+			if err != nil {
+				t.Fatalf("Build() error: %v", err)
+			}
+			if handler.Tag != "test_out_0" {
+				t.Errorf("Build() tag = %q, want test_out_0", handler.Tag)
+			}
+			if handler.ProxySettings == nil || handler.SenderSettings == nil {
+				t.Errorf("Build() left proxy or sender settings empty")
+			}
+		})
+	}
+}
+
+func TestUnsupportedReason(t *testing.T) {
+	tests := []struct {
+		name      string
+		node      types.Proxy
+		supported bool
+	}{
+		{"vmess tcp", types.Proxy{Type: "vmess"}, true},
+		{"vless raw", types.Proxy{Type: "vless", Network: "raw"}, true},
+		{"trojan websocket alias", types.Proxy{Type: "trojan", Network: "websocket"}, true},
+		{"vless httpupgrade", types.Proxy{Type: "vless", Network: "httpupgrade"}, true},
+		{"ss aead", types.Proxy{Type: "ss", Cipher: "chacha20-ietf-poly1305"}, true},
+		{"ss 2022", types.Proxy{Type: "ss", Cipher: "2022-blake3-aes-128-gcm"}, true},
+		{"ss stream cipher", types.Proxy{Type: "ss", Cipher: "aes-256-cfb"}, false},
+		{"ssr", types.Proxy{Type: "ssr", Cipher: "aes-256-cfb"}, false},
+		{"hysteria2", types.Proxy{Type: "hysteria2", Network: "udp"}, false},
+		{"vmess h2", types.Proxy{Type: "vmess", Network: "h2"}, false},
+		{"vless quic", types.Proxy{Type: "vless", Network: "quic"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reason := unsupportedReason(tt.node)
+			if (reason == "") != tt.supported {
+				t.Errorf("unsupportedReason() = %q, want supported %t", reason, tt.supported)
+			}
+			if Supported(tt.node) != tt.supported {
+				t.Errorf("Supported() = %t, want %t", !tt.supported, tt.supported)
+			}
+		})
+	}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"subs-check-custom/clash"
+	"subs-check-custom/singbox"
+	"subs-check-custom/types"
+)
+
+// Output formats selectable under outputs: in config.yaml
+const (
+	OutputURIs    = "uris"    // Share links, one per line
+	OutputBase64  = "base64"  // Share links as a base64 subscription body for v2rayN and NekoBox
+	OutputClash   = "clash"   // Clash proxies: list
+	OutputProfile = "profile" // Complete mihomo config; options: template
+	OutputSingBox = "singbox" // sing-box outbounds; options: full, multiplex
+	OutputReport  = "report"  // JSON test report without credentials
+)
+
+// outputWriter renders nodes in one format for the given output
+type outputWriter func(cfg types.Config, output types.OutputConfig, nodes []types.Proxy) ([]byte, error)
+
+// outputWriters maps each format to its writer
+var outputWriters = map[string]outputWriter{
+	OutputURIs:    writeURIs,
+	OutputBase64:  writeBase64,
+	OutputClash:   writeClash,
+	OutputProfile: writeProfile,
+	OutputSingBox: writeSingBox,
+	OutputReport:  writeReport,
+}
+
+// configuredOutputs returns cfg.Outputs, or the outputs named by the
+// single-file keys (allOutputFile, uniqueNodesFile, profile-file and
+// singbox-file) when no outputs are configured
+func configuredOutputs(cfg types.Config) []types.OutputConfig {
+	if len(cfg.Outputs) > 0 {
+		return cfg.Outputs
+	}
+	var outputs []types.OutputConfig
+	if cfg.UniqueNodesFile != "" {
+		outputs = append(outputs, types.OutputConfig{Format: OutputURIs, Path: cfg.UniqueNodesFile})
+	}
+	if cfg.AllOutputFile != "" {
+		outputs = append(outputs, types.OutputConfig{Format: OutputClash, Path: cfg.AllOutputFile})
+	}
+	if cfg.ProfileFile != "" {
+		outputs = append(outputs, types.OutputConfig{Format: OutputProfile, Path: cfg.ProfileFile,
+			Options: map[string]string{"template": cfg.ProfileTemplate}})
+	}
+	if cfg.SingBoxFile != "" {
+		outputs = append(outputs, types.OutputConfig{Format: OutputSingBox, Path: cfg.SingBoxFile,
+			Options: map[string]string{"full": strconv.FormatBool(cfg.SingBoxFull), "multiplex": cfg.SingBoxMultiplex}})
+	}
+	return outputs
+}
+
+// writeOutputs writes nodes to every configured output. A failed output is
+// logged and does not stop the others.
+func writeOutputs(cfg types.Config, nodes []types.Proxy) {
+	for _, output := range configuredOutputs(cfg) {
+		format := strings.ToLower(output.Format)
+		writer, ok := outputWriters[format]
+		if !ok {
+			log.Printf("Unknown output format '%s' for %s, skipping", output.Format, output.Path)
+			continue
+		}
+		if output.Path == "" {
+			log.Printf("Output %s has no path, skipping", format)
+			continue
+		}
+		data, err := writer(cfg, output, nodes)
+		if err == nil {
+			err = os.WriteFile(output.Path, data, 0644)
+		}
+		if err != nil {
+			log.Printf("Failed to save %s output to %s: %v", format, output.Path, err)
+		} else {
+			log.Printf("Saved %s output to %s", format, output.Path)
+		}
+	}
+}
+
+// outputOption returns a boolean option, false when unset or invalid
+func outputOption(output types.OutputConfig, key string) bool {
+	value, _ := strconv.ParseBool(output.Options[key])
+	return value
+}
+
+func writeURIs(cfg types.Config, output types.OutputConfig, nodes []types.Proxy) ([]byte, error) {
+	var b strings.Builder
+	for _, uri := range nodeURIs(nodes) {
+		b.WriteString(uri + "\r\n")
+	}
+	return []byte(b.String()), nil
+}
+
+func writeBase64(cfg types.Config, output types.OutputConfig, nodes []types.Proxy) ([]byte, error) {
+	body := strings.Join(nodeURIs(nodes), "\n")
+	return []byte(base64.StdEncoding.EncodeToString([]byte(body))), nil
+}
+
+func writeClash(cfg types.Config, output types.OutputConfig, nodes []types.Proxy) ([]byte, error) {
+	proxies := clash.ConvertAll(nodes, func(node types.Proxy, err error) {
+		log.Printf("Left %s out of %s: %v", node.Name, output.Path, err)
+	})
+	if len(proxies) == 0 {
+		proxies = []clash.Proxy{{Name: "No usable nodes | ⬇️ 0.0MB/s"}}
+	}
+	return yaml.Marshal(map[string][]clash.Proxy{"proxies": proxies})
+}
+
+func writeProfile(cfg types.Config, output types.OutputConfig, nodes []types.Proxy) ([]byte, error) {
+	return renderProfile(cfg, output.Options["template"], nodes)
+}
+
+// writeSingBox writes an outbounds array, or a complete config with the full option
+func writeSingBox(cfg types.Config, output types.OutputConfig, nodes []types.Proxy) ([]byte, error) {
+	outbounds := singbox.ConvertAll(nodes, output.Options["multiplex"], func(node types.Proxy, err error) {
+		log.Printf("Left %s out of %s: %v", node.Name, output.Path, err)
+	})
+	var config any = map[string]any{"outbounds": outbounds}
+	if outputOption(output, "full") {
+		if len(outbounds) == 0 {
+			return nil, fmt.Errorf("no nodes sing-box can carry")
+		}
+		testURL := cfg.LatencyURL
+		if testURL == "" {
+			testURL = defaultLatencyURL
+		}
+		config = singbox.Config(outbounds, testURL)
+	}
+	return indentedJSON(config)
+}
+
+// reportNode is a node's test results in the JSON report
+type reportNode struct {
+	Name        string                        `json:"name"`
+	Type        string                        `json:"type"`
+	Server      string                        `json:"server"`
+	Port        int                           `json:"port"`
+	Source      string                        `json:"source,omitempty"`
+	Country     string                        `json:"country,omitempty"`
+	ASN         uint                          `json:"asn,omitempty"`
+	Org         string                        `json:"org,omitempty"`
+	EgressIP    string                        `json:"egress_ip,omitempty"`
+	Latency     int64                         `json:"latency_ms,omitempty"`
+	Speed       float64                       `json:"speed_kbps,omitempty"`
+	PeakSpeed   float64                       `json:"peak_speed_kbps,omitempty"`
+	UploadSpeed float64                       `json:"upload_kbps,omitempty"`
+	UDP         *bool                         `json:"udp,omitempty"`
+	UDPLatency  float64                       `json:"udp_latency_ms,omitempty"`
+	Unlock      map[string]string             `json:"unlock,omitempty"`
+	Ping        map[string]types.LatencyStats `json:"ping,omitempty"`
+	History     *types.NodeStats              `json:"history,omitempty"`
+	Score       float64                       `json:"score,omitempty"`
+	Failure     *types.TestFailure            `json:"failure,omitempty"`
+}
+
+// writeReport writes every node's results, leaving out credentials
+func writeReport(cfg types.Config, output types.OutputConfig, nodes []types.Proxy) ([]byte, error) {
+	report := struct {
+		Generated time.Time    `json:"generated"`
+		Nodes     []reportNode `json:"nodes"`
+	}{Generated: time.Now(), Nodes: make([]reportNode, 0, len(nodes))}
+
+	for _, node := range nodes {
+		geo := node.Geo()
+		entry := reportNode{
+			Name:        node.Name,
+			Type:        node.Type,
+			Server:      node.Server,
+			Port:        node.Port,
+			Source:      node.Source,
+			Country:     geo.Country,
+			ASN:         geo.ASN,
+			Org:         geo.Org,
+			EgressIP:    node.EgressIP,
+			Latency:     node.Latency,
+			Speed:       node.Speed,
+			PeakSpeed:   node.PeakSpeed,
+			UploadSpeed: node.UploadSpeed,
+			UDP:         node.UDP,
+			UDPLatency:  node.UDPLatency,
+			Unlock:      node.Tags,
+			History:     node.History,
+			Score:       node.Score,
+			Failure:     node.Failure,
+		}
+		for name, stats := range map[string]types.LatencyStats{"tcp": node.TCPPing, "tls": node.TLSPing, "http": node.HTTPPing} {
+			if stats.Runs > 0 {
+				if entry.Ping == nil {
+					entry.Ping = make(map[string]types.LatencyStats)
+				}
+				entry.Ping[name] = stats
+			}
+		}
+		report.Nodes = append(report.Nodes, entry)
+	}
+	return indentedJSON(report)
+}
+
+// indentedJSON marshals v for people to read, keeping characters such as & as they are
+func indentedJSON(v any) ([]byte, error) {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
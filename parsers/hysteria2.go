@@ -3,7 +3,6 @@ package parsers
 import (
 	"log"
 	"net/url"
-	"strconv"
 	"strings"
 
 	"subs-check-custom/types" // Updated import
@@ -21,55 +20,33 @@ func ParseHysteria2(line string, i int, simpleLogger *log.Logger, stats *types.P
 		return nil
 	}
 	password := parts[0]
-	serverPortPart := parts[1]
-	serverPortQuery := strings.SplitN(serverPortPart, "?", 2)
-	serverPort := strings.SplitN(serverPortQuery[0], ":", 2)
-	if len(serverPort) != 2 {
-		log.Printf("Invalid server:port format for line %d (%s)", i, line)
-		simpleLogger.Printf("Line %d: Fail - Invalid server:port format", i)
+	// The fragment holds the name and may itself contain '?' or ':'
+	rest, fragment, hasName := strings.Cut(parts[1], "#")
+	hostPort, rawQuery, _ := strings.Cut(rest, "?")
+	server, port, err := splitHostPort(strings.TrimSuffix(hostPort, "/"))
+	if err != nil {
+		log.Printf("Invalid server:port format for line %d (%s): %v", i, line, err)
+		simpleLogger.Printf("Line %d: Fail - Invalid server:port format: %v", i, err)
 		stats.Hysteria2Fail++
 		stats.TotalFail++
 		return nil
 	}
-	server := serverPort[0]
-	port, err := strconv.Atoi(serverPort[1])
+	params, err := url.ParseQuery(rawQuery)
 	if err != nil {
-		log.Printf("Invalid port for line %d (%s): %v", i, line, err)
-		simpleLogger.Printf("Line %d: Fail - Invalid port: %v", i, err)
+		log.Printf("Invalid query for line %d (%s): %v", i, line, err)
+		simpleLogger.Printf("Line %d: Fail - Invalid query: %v", i, err)
 		stats.Hysteria2Fail++
 		stats.TotalFail++
 		return nil
 	}
-	hashIndex := strings.Index(serverPortPart, "#")
-	var name string
-	if hashIndex > -1 {
-		name = strings.TrimSpace(serverPortPart[hashIndex+1:])
-	} else {
+	name := strings.TrimSpace(fragment)
+	if !hasName {
 		name = "Hysteria2_Proxy_0" // Will be overridden later if needed
 	}
-	sni := ""
-	skipCert := false
-	obfs := ""
-	obfsPassword := ""
-	if len(serverPortQuery) > 1 {
-		query := serverPortQuery[1]
-		queryParams := strings.Split(query, "&")
-		for _, param := range queryParams {
-			if strings.HasPrefix(param, "sni=") {
-				sni = strings.TrimPrefix(param, "sni=")
-			}
-			if strings.HasPrefix(param, "insecure=") {
-				insecure := strings.TrimPrefix(param, "insecure=")
-				skipCert = (insecure == "1")
-			}
-			if strings.HasPrefix(param, "obfs=") {
-				obfs = strings.TrimPrefix(param, "obfs=")
-			}
-			if strings.HasPrefix(param, "obfs-password=") {
-				obfsPassword = strings.TrimPrefix(param, "obfs-password=")
-			}
-		}
-	}
+	sni := params.Get("sni")
+	skipCert := params.Get("insecure") == "1"
+	obfs := params.Get("obfs")
+	obfsPassword := params.Get("obfs-password")
 
 	if strings.Contains(name, "%") {
 		decodedName, err := url.QueryUnescape(name)
@@ -0,0 +1,119 @@
+package parsers
+
+import (
+	"encoding/base64"
+	"log"
+	"strconv"
+	"strings"
+
+	"subs-check-custom/types"
+)
+
+// ParseSSR parses a ShadowsocksR proxy URL of the form
+// ssr://base64(server:port:protocol:method:obfs:base64(password)/?obfsparam=...&protoparam=...&remarks=...)
+func ParseSSR(line string, i int, simpleLogger *log.Logger, stats *types.ProxyStats) *types.Proxy {
+	ssrPart := strings.TrimPrefix(line, "ssr://")
+	decoded, err := decodeBase64Any(ssrPart)
+	if err != nil {
+		log.Printf("Fail: Base64 decode for line %d (%s): %v", i, line, err)
+		simpleLogger.Printf("Line %d: Fail - Base64 decode error: %v", i, err)
+		stats.SSRFail++
+		stats.TotalFail++
+		return nil
+	}
+
+	body := string(decoded)
+	query := ""
+	if idx := strings.Index(body, "/?"); idx > -1 {
+		query = body[idx+2:]
+		body = body[:idx]
+	} else if idx := strings.Index(body, "?"); idx > -1 {
+		query = body[idx+1:]
+		body = body[:idx]
+	}
+	body = strings.TrimSuffix(body, "/")
+
+	// The server may be an IPv6 address containing colons, so split from the right
+	fields := strings.Split(body, ":")
+	if len(fields) < 6 {
+		log.Printf("Invalid SSR format for line %d (%s)", i, line)
+		simpleLogger.Printf("Line %d: Fail - Invalid SSR format", i)
+		stats.SSRFail++
+		stats.TotalFail++
+		return nil
+	}
+	n := len(fields)
+	server := strings.Join(fields[:n-5], ":")
+	server = strings.TrimSuffix(strings.TrimPrefix(server, "["), "]")
+	port, err := strconv.Atoi(fields[n-5])
+	if err != nil {
+		log.Printf("Invalid port for line %d (%s): %v", i, line, err)
+		simpleLogger.Printf("Line %d: Fail - Invalid port: %v", i, err)
+		stats.SSRFail++
+		stats.TotalFail++
+		return nil
+	}
+	protocol := fields[n-4]
+	cipher := fields[n-3]
+	obfs := fields[n-2]
+	password, err := decodeBase64Any(fields[n-1])
+	if err != nil {
+		log.Printf("Fail: Base64 decode for password in line %d (%s): %v", i, line, err)
+		simpleLogger.Printf("Line %d: Fail - Invalid SSR password: %v", i, err)
+		stats.SSRFail++
+		stats.TotalFail++
+		return nil
+	}
+
+	name := "SSR_Proxy_0" // Will be overridden later if needed
+	obfsParam := ""
+	protocolParam := ""
+	for _, param := range strings.Split(query, "&") {
+		kv := strings.SplitN(param, "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			continue
+		}
+		value, err := decodeBase64Any(kv[1])
+		if err != nil {
+			log.Printf("Warning: Invalid base64 in SSR param %s for line %d: %v", kv[0], i, err)
+			continue
+		}
+		switch kv[0] {
+		case "remarks":
+			name = strings.TrimSpace(string(value))
+		case "obfsparam":
+			obfsParam = string(value)
+		case "protoparam":
+			protocolParam = string(value)
+		}
+	}
+	name = strings.Split(name, " |")[0]
+
+	proxy := &types.Proxy{
+		Name:          name,
+		Server:        server,
+		Port:          port,
+		Type:          "ssr",
+		Cipher:        cipher,
+		Password:      string(password),
+		Protocol:      protocol,
+		ProtocolParam: protocolParam,
+		Obfs:          obfs,
+		ObfsParam:     obfsParam,
+		Network:       "tcp",
+	}
+	simpleLogger.Printf("Line %d: Success - SSR proxy parsed", i)
+	stats.SSRSuccess++
+	stats.TotalSuccess++
+	return proxy
+}
+
+// decodeBase64Any decodes standard or URL-safe base64, with or without padding
+func decodeBase64Any(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	s = strings.TrimRight(s, "=")
+	if strings.ContainsAny(s, "-_") {
+		return base64.RawURLEncoding.DecodeString(s)
+	}
+	return base64.RawStdEncoding.DecodeString(s)
+}
@@ -0,0 +1,94 @@
+package parsers
+
+import (
+	"encoding/base64"
+	"io"
+	"log"
+	"os"
+	"reflect"
+	"testing"
+
+	"subs-check-custom/types"
+)
+
+type parseFunc func(line string, i int, simpleLogger *log.Logger, stats *types.ProxyStats) *types.Proxy
+
+// parseLine runs parse on line with the logs discarded
+func parseLine(t *testing.T, parse parseFunc, line string) (*types.Proxy, types.ProxyStats) {
+	t.Helper()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	var stats types.ProxyStats
+	return parse(line, 1, log.New(io.Discard, "", 0), &stats), stats
+}
+
+func b64(s string) string    { return base64.StdEncoding.EncodeToString([]byte(s)) }
+func b64url(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+
+func TestParseSSR(t *testing.T) {
+	full := types.Proxy{
+		Name: "香港 01", Server: "hk.example.com", Port: 8388, Type: "ssr", Cipher: "aes-256-cfb", Password: "pa:ss",
+		Protocol: "auth_aes128_md5", ProtocolParam: "123:abc", Obfs: "tls1.2_ticket_auth", ObfsParam: "cdn.example.com", Network: "tcp",
+	}
+	plain := types.Proxy{
+		Name: "SSR_Proxy_0", Server: "1.2.3.4", Port: 443, Type: "ssr", Cipher: "chacha20", Password: "secret",
+		Protocol: "origin", Obfs: "plain", Network: "tcp",
+	}
+	v6 := plain
+	v6.Server = "2001:db8::1"
+
+	query := "obfsparam=" + b64url("cdn.example.com") + "&protoparam=" + b64("123:abc") +
+		"&remarks=" + b64url("香港 01 | 1x") + "&group=" + b64url("Provider")
+	body := "hk.example.com:8388:auth_aes128_md5:aes-256-cfb:tls1.2_ticket_auth:" + b64("pa:ss")
+
+	tests := []struct {
+		name string
+		link string
+		want types.Proxy
+	}{
+		{"remarks and group", "ssr://" + b64(body+"/?"+query), full},
+		{"url-safe unpadded", "ssr://" + b64url(body+"/?"+query), full},
+		{"query without slash", "ssr://" + b64url(body+"?"+query), full},
+		{"no query", "ssr://" + b64("1.2.3.4:443:origin:chacha20:plain:"+b64("secret")), plain},
+		{"empty params", "ssr://" + b64("1.2.3.4:443:origin:chacha20:plain:"+b64("secret")+"/?obfsparam=&remarks="), plain},
+		{"group only", "ssr://" + b64("1.2.3.4:443:origin:chacha20:plain:"+b64("secret")+"/?group="+b64url("Provider")), plain},
+		{"bad remarks", "ssr://" + b64("1.2.3.4:443:origin:chacha20:plain:"+b64("secret")+"/?remarks=%%%"), plain},
+		{"ipv6", "ssr://" + b64("2001:db8::1:443:origin:chacha20:plain:"+b64("secret")), v6},
+		{"bracketed ipv6", "ssr://" + b64("[2001:db8::1]:443:origin:chacha20:plain:"+b64("secret")), v6},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy, stats := parseLine(t, ParseSSR, tt.link)
+			if proxy == nil {
+				t.Fatalf("failed to parse %s", tt.link)
+			}
+			if !reflect.DeepEqual(*proxy, tt.want) {
+				t.Errorf("parsed\n%+v\nwant\n%+v", *proxy, tt.want)
+			}
+			if stats.SSRSuccess != 1 || stats.TotalSuccess != 1 || stats.SSRFail != 0 {
+				t.Errorf("stats = %+v", stats)
+			}
+		})
+	}
+}
+
+func TestParseSSRMalformed(t *testing.T) {
+	tests := map[string]string{
+		"not base64":     "ssr://%%%not-base64%%%",
+		"too few fields": "ssr://" + b64("1.2.3.4:443:origin:chacha20"),
+		"bad port":       "ssr://" + b64("1.2.3.4:http:origin:chacha20:plain:"+b64("secret")),
+		"bad password":   "ssr://" + b64("1.2.3.4:443:origin:chacha20:plain:!!!"),
+		"empty":          "ssr://",
+	}
+	for name, link := range tests {
+		t.Run(name, func(t *testing.T) {
+			proxy, stats := parseLine(t, ParseSSR, link)
+			if proxy != nil {
+				t.Errorf("parsed %+v", *proxy)
+			}
+			if stats.SSRFail != 1 || stats.TotalFail != 1 || stats.SSRSuccess != 0 {
+				t.Errorf("stats = %+v, want one SSR failure", stats)
+			}
+		})
+	}
+}
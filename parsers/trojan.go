@@ -3,7 +3,6 @@ package parsers
 import (
 	"log"
 	"net/url"
-	"strconv"
 	"strings"
 
 	"subs-check-custom/types"
@@ -21,48 +20,54 @@ func ParseTrojan(line string, i int, simpleLogger *log.Logger, stats *types.Prox
 		return nil
 	}
 	password := parts[0]
-	serverPortPart := parts[1]
-	serverPort := strings.SplitN(serverPortPart, ":", 2)
-	if len(serverPort) != 2 {
-		log.Printf("Invalid server:port format for line %d (%s)", i, line)
-		simpleLogger.Printf("Line %d: Fail - Invalid server:port format", i)
+	// The fragment holds the name and may itself contain '?' or ':'
+	rest, fragment, hasName := strings.Cut(parts[1], "#")
+	hostPort, rawQuery, _ := strings.Cut(rest, "?")
+	server, port, err := splitHostPort(hostPort)
+	if err != nil {
+		log.Printf("Invalid server:port format for line %d (%s): %v", i, line, err)
+		simpleLogger.Printf("Line %d: Fail - Invalid server:port format: %v", i, err)
 		stats.TrojanFail++
 		stats.TotalFail++
 		return nil
 	}
-	server := serverPort[0]
-	portQuery := strings.SplitN(serverPort[1], "?", 2)
-	portStr := portQuery[0]
-	port, err := strconv.Atoi(portStr)
+	params, err := url.ParseQuery(rawQuery)
 	if err != nil {
-		log.Printf("Invalid port for line %d (%s): %v", i, line, err)
-		simpleLogger.Printf("Line %d: Fail - Invalid port: %v", i, err)
+		log.Printf("Invalid query for line %d (%s): %v", i, line, err)
+		simpleLogger.Printf("Line %d: Fail - Invalid query: %v", i, err)
 		stats.TrojanFail++
 		stats.TotalFail++
 		return nil
 	}
-	hashIndex := strings.Index(serverPortPart, "#")
-	var name string
-	if hashIndex > -1 {
-		name = strings.TrimSpace(serverPortPart[hashIndex+1:])
-	} else {
+	name := strings.TrimSpace(fragment)
+	if !hasName {
 		name = "Trojan_Proxy_0" // Will be overridden later if needed
 	}
-	sni := ""
-	skipCert := false
-	if len(portQuery) > 1 {
-		query := portQuery[1]
-		queryParams := strings.Split(query, "&")
-		for _, param := range queryParams {
-			if strings.HasPrefix(param, "sni=") {
-				sni = strings.TrimPrefix(param, "sni=")
-			}
-			if strings.HasPrefix(param, "allowInsecure=") {
-				allowInsecure := strings.TrimPrefix(param, "allowInsecure=")
-				skipCert = (allowInsecure == "1")
-			}
-		}
+	network := "tcp"
+	if t := params.Get("type"); t != "" {
+		network = t
+	}
+	wsOpts := make(map[string]string)
+	if host := params.Get("host"); host != "" {
+		wsOpts["host"] = host
+	}
+	if path := params.Get("path"); path != "" {
+		wsOpts["path"] = path
+	}
+	grpcOpts := make(map[string]string)
+	if serviceName := params.Get("serviceName"); serviceName != "" {
+		grpcOpts["grpc-service-name"] = serviceName
+	}
+	var alpn []string
+	if value := params.Get("alpn"); value != "" {
+		alpn = strings.Split(value, ",")
+	}
+	sni := params.Get("sni")
+	if sni == "" {
+		sni = params.Get("peer")
 	}
+	skipCert := params.Get("allowInsecure") == "1"
+	fingerprint := params.Get("fp")
 
 	if strings.Contains(name, "%") {
 		decodedName, err := url.QueryUnescape(name)
@@ -73,15 +78,20 @@ func ParseTrojan(line string, i int, simpleLogger *log.Logger, stats *types.Prox
 	name = strings.Split(name, " |")[0]
 
 	proxy := &types.Proxy{
-		Name:           name,
-		Server:         server,
-		Port:           port,
-		Type:           "trojan",
-		Cipher:         "",
-		Password:       password,
-		SkipCertVerify: skipCert,
-		SNI:            sni,
-		Network:        "tcp",
+		Name:              name,
+		Server:            server,
+		Port:              port,
+		Type:              "trojan",
+		Cipher:            "",
+		Password:          password,
+		SkipCertVerify:    skipCert,
+		SNI:               sni,
+		ALPN:              alpn,
+		ClientFingerprint: fingerprint,
+		Network:           network,
+		WSOpts:            wsOpts,
+		GrpcOpts:          grpcOpts,
+		TLS:               true, // Trojan always runs over TLS
 	}
 	simpleLogger.Printf("Line %d: Success - Trojan proxy parsed", i)
 	stats.TrojanSuccess++
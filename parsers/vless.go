@@ -3,7 +3,6 @@ package parsers
 import (
 	"log"
 	"net/url"
-	"strconv"
 	"strings"
 
 	"subs-check-custom/types"
@@ -21,65 +20,61 @@ func ParseVLess(line string, i int, simpleLogger *log.Logger, stats *types.Proxy
 		return nil
 	}
 	uuid := parts[0]
-	serverPortPart := parts[1]
-	serverPortQuery := strings.SplitN(serverPortPart, "?", 2)
-	serverPort := strings.SplitN(serverPortQuery[0], ":", 2)
-	if len(serverPort) != 2 {
-		log.Printf("Invalid server:port format for line %d (%s)", i, line)
-		simpleLogger.Printf("Line %d: Fail - Invalid server:port format", i)
+	// The fragment holds the name and may itself contain '?' or ':'
+	rest, fragment, hasName := strings.Cut(parts[1], "#")
+	hostPort, rawQuery, _ := strings.Cut(rest, "?")
+	server, port, err := splitHostPort(hostPort)
+	if err != nil {
+		log.Printf("Invalid server:port format for line %d (%s): %v", i, line, err)
+		simpleLogger.Printf("Line %d: Fail - Invalid server:port format: %v", i, err)
 		stats.VLessFail++
 		stats.TotalFail++
 		return nil
 	}
-	server := serverPort[0]
-	port, err := strconv.Atoi(serverPort[1])
+	params, err := url.ParseQuery(rawQuery)
 	if err != nil {
-		log.Printf("Invalid port for line %d (%s): %v", i, line, err)
-		simpleLogger.Printf("Line %d: Fail - Invalid port: %v", i, err)
+		log.Printf("Invalid query for line %d (%s): %v", i, line, err)
+		simpleLogger.Printf("Line %d: Fail - Invalid query: %v", i, err)
 		stats.VLessFail++
 		stats.TotalFail++
 		return nil
 	}
-	hashIndex := strings.Index(serverPortPart, "#")
-	var name string
-	if hashIndex > -1 {
-		name = strings.TrimSpace(serverPortPart[hashIndex+1:])
-	} else {
+	name := strings.TrimSpace(fragment)
+	if !hasName {
 		name = "VLess_Proxy_0" // Will be overridden later if needed
 	}
-	sni := ""
-	skipCert := false
 	network := "tcp"
+	if t := params.Get("type"); t != "" {
+		network = t
+	}
 	wsOpts := make(map[string]string)
-	tls := false
-	if len(serverPortQuery) > 1 {
-		query := serverPortQuery[1]
-		queryParams := strings.Split(query, "&")
-		for _, param := range queryParams {
-			if strings.HasPrefix(param, "sni=") {
-				sni = strings.TrimPrefix(param, "sni=")
-			}
-			if strings.HasPrefix(param, "allowInsecure=") {
-				allowInsecure := strings.TrimPrefix(param, "allowInsecure=")
-				skipCert = (allowInsecure == "1")
-			}
-			if strings.HasPrefix(param, "type=") {
-				network = strings.TrimPrefix(param, "type=")
-			}
-			if strings.HasPrefix(param, "host=") {
-				wsOpts["host"] = strings.TrimPrefix(param, "host=")
-			}
-			if strings.HasPrefix(param, "path=") {
-				wsOpts["path"] = strings.TrimPrefix(param, "path=")
-			}
-			if strings.HasPrefix(param, "security=") {
-				security := strings.TrimPrefix(param, "security=")
-				if security == "tls" {
-					tls = true
-				}
-			}
-		}
+	if host := params.Get("host"); host != "" {
+		wsOpts["host"] = host
+	}
+	if path := params.Get("path"); path != "" {
+		wsOpts["path"] = path
+	}
+	grpcOpts := make(map[string]string)
+	if serviceName := params.Get("serviceName"); serviceName != "" {
+		grpcOpts["grpc-service-name"] = serviceName
+	}
+	realityOpts := make(map[string]string)
+	if key := params.Get("pbk"); key != "" {
+		realityOpts["public-key"] = key
+	}
+	if shortID := params.Get("sid"); shortID != "" {
+		realityOpts["short-id"] = shortID
+	}
+	var alpn []string
+	if value := params.Get("alpn"); value != "" {
+		alpn = strings.Split(value, ",")
 	}
+	security := params.Get("security")
+	tls := security == "tls" || security == "reality"
+	sni := params.Get("sni")
+	skipCert := params.Get("allowInsecure") == "1"
+	flow := params.Get("flow")
+	fingerprint := params.Get("fp")
 
 	if strings.Contains(name, "%") {
 		decodedName, err := url.QueryUnescape(name)
@@ -90,16 +85,21 @@ func ParseVLess(line string, i int, simpleLogger *log.Logger, stats *types.Proxy
 	name = strings.Split(name, " |")[0]
 
 	proxy := &types.Proxy{
-		Name:           name,
-		Server:         server,
-		Port:           port,
-		Type:           "vless",
-		UUID:           uuid,
-		Network:        network,
-		WSOpts:         wsOpts,
-		SkipCertVerify: skipCert,
-		TLS:            tls,
-		SNI:            sni,
+		Name:              name,
+		Server:            server,
+		Port:              port,
+		Type:              "vless",
+		UUID:              uuid,
+		Network:           network,
+		WSOpts:            wsOpts,
+		GrpcOpts:          grpcOpts,
+		RealityOpts:       realityOpts,
+		SkipCertVerify:    skipCert,
+		TLS:               tls,
+		SNI:               sni,
+		ALPN:              alpn,
+		ClientFingerprint: fingerprint,
+		Flow:              flow,
 	}
 	simpleLogger.Printf("Line %d: Success - VLess proxy parsed", i)
 	stats.VLessSuccess++
@@ -0,0 +1,117 @@
+package parsers
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"subs-check-custom/types"
+)
+
+const testUUID = "b831381d-6324-4d53-ad4f-8cda48b30811"
+
+func TestParseVMess(t *testing.T) {
+	jsonWS := `{"v":"2","ps":"日本%20节点 | 2x","add":"jp.example.com","port":"443","id":"` + testUUID + `","aid":"0",` +
+		`"scy":"","net":"ws","type":"none","host":"cdn.example.com","path":"/ray","tls":"tls","sni":"jp.example.com"}`
+	// The ps value makes the URL-safe alphabet show up in the encoding
+	jsonGRPC := `{"v":2,"ps":"g>>??~~","add":"grpc.example.com","port":8443,"id":"` + testUUID + `","aid":64,` +
+		`"scy":"aes-128-gcm","net":"grpc","path":"svc","tls":true,"skip-cert-verify":true}`
+	if encoded := b64url(jsonGRPC); !strings.ContainsAny(encoded, "-_") {
+		t.Fatalf("%s does not exercise the URL-safe alphabet", encoded)
+	}
+	shadowrocket := "vmess://" + b64url("auto:"+testUUID+"@sr.example.com:443") +
+		"?remarks=SR%20Node%20%7C%201x&obfsParam=cdn.example.com&path=/ws&obfs=websocket&tls=1&peer=sni.example.com&alterId=2&allowInsecure=1"
+	quantumult := "vmess://" + b64(`QX Node = vmess, qx.example.com, 443, chacha20-ietf-poly1305, "`+testUUID+`", group=Provider, `+
+		`over-tls=true, certificate=0, obfs=wss, obfs-path="/path", obfs-header="Host: cdn.example.com[Rr][Nn]User-Agent: x", tls-host=sni.example.com`)
+
+	tests := []struct {
+		name string
+		link string
+		want types.Proxy
+	}{
+		{"json", "vmess://" + b64(jsonWS), types.Proxy{
+			Name: "日本 节点", Server: "jp.example.com", Port: 443, Type: "vmess", Cipher: "auto", Password: testUUID, UUID: testUUID,
+			Network: "ws", WSOpts: map[string]string{"path": "/ray", "host": "cdn.example.com"}, GrpcOpts: map[string]string{},
+			TLS: true, SNI: "jp.example.com",
+		}},
+		{"json url-safe unpadded", "vmess://" + b64url(jsonGRPC), types.Proxy{
+			Name: "g>>??~~", Server: "grpc.example.com", Port: 8443, Type: "vmess", Cipher: "aes-128-gcm", Password: testUUID, UUID: testUUID,
+			AlterID: 64, Network: "grpc", WSOpts: map[string]string{}, GrpcOpts: map[string]string{"grpc-service-name": "svc"},
+			TLS: true, SkipCertVerify: true,
+		}},
+		{"shadowrocket", shadowrocket, types.Proxy{
+			Name: "SR Node", Server: "sr.example.com", Port: 443, Type: "vmess", Cipher: "auto", Password: testUUID, UUID: testUUID,
+			AlterID: 2, Network: "ws", WSOpts: map[string]string{"path": "/ws", "host": "cdn.example.com"},
+			TLS: true, SNI: "sni.example.com", SkipCertVerify: true,
+		}},
+		{"shadowrocket header json and fragment", "vmess://" + b64("none:"+testUUID+"@[2001:db8::3]:80") +
+			`?obfs=ws&obfsParam=%7B%22Host%22:%22h.example.com%22%7D#Frag%20Name`, types.Proxy{
+			Name: "Frag Name", Server: "2001:db8::3", Port: 80, Type: "vmess", Cipher: "none", Password: testUUID, UUID: testUUID,
+			Network: "ws", WSOpts: map[string]string{"host": "h.example.com"},
+		}},
+		{"quantumult", quantumult, types.Proxy{
+			Name: "QX Node", Server: "qx.example.com", Port: 443, Type: "vmess", Cipher: "chacha20-ietf-poly1305", Password: testUUID, UUID: testUUID,
+			Network: "ws", WSOpts: map[string]string{"path": "/path", "host": "cdn.example.com"},
+			TLS: true, SNI: "sni.example.com", SkipCertVerify: true,
+		}},
+		{"aead url", "vmess://" + testUUID + "@aead.example.com:443?type=ws&security=tls&path=%2Fws%3Fed%3D2048&host=cdn.example.com&sni=s.example.com&encryption=none&aid=0#AEAD%20Node",
+			types.Proxy{
+				Name: "AEAD Node", Server: "aead.example.com", Port: 443, Type: "vmess", Cipher: "auto", Password: testUUID, UUID: testUUID,
+				Network: "ws", WSOpts: map[string]string{"path": "/ws?ed=2048", "host": "cdn.example.com"}, TLS: true, SNI: "s.example.com",
+			}},
+		{"aead url ipv6", "vmess://" + testUUID + "@[2001:db8::2]:8443?encryption=aes-128-gcm#v6", types.Proxy{
+			Name: "v6", Server: "2001:db8::2", Port: 8443, Type: "vmess", Cipher: "aes-128-gcm", Password: testUUID, UUID: testUUID,
+			Network: "tcp", WSOpts: map[string]string{},
+		}},
+		{"aead url unnamed", "vmess://" + testUUID + "@aead.example.com:443", types.Proxy{
+			Name: "VMess_Proxy_0", Server: "aead.example.com", Port: 443, Type: "vmess", Cipher: "auto", Password: testUUID, UUID: testUUID,
+			Network: "tcp", WSOpts: map[string]string{},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxy, stats := parseLine(t, ParseVMess, tt.link)
+			if proxy == nil {
+				t.Fatalf("failed to parse %s", tt.link)
+			}
+			if !reflect.DeepEqual(*proxy, tt.want) {
+				t.Errorf("parsed\n%+v\nwant\n%+v", *proxy, tt.want)
+			}
+			if stats.VMessSuccess != 1 || stats.TotalSuccess != 1 || stats.VMessFail != 0 {
+				t.Errorf("stats = %+v", stats)
+			}
+		})
+	}
+}
+
+func TestParseVMessMalformed(t *testing.T) {
+	tests := map[string]string{
+		"not base64":             "vmess://%%%not-base64%%%",
+		"broken json":            "vmess://" + b64(`{"add":"a.example.com",`),
+		"json bad port":          "vmess://" + b64(`{"add":"a.example.com","port":"http","id":"`+testUUID+`"}`),
+		"json port type":         "vmess://" + b64(`{"add":"a.example.com","port":true,"id":"`+testUUID+`"}`),
+		"json aid type":          "vmess://" + b64(`{"add":"a.example.com","port":443,"id":"`+testUUID+`","aid":{}}`),
+		"json tls type":          "vmess://" + b64(`{"add":"a.example.com","port":443,"id":"`+testUUID+`","tls":1}`),
+		"shadowrocket no at":     "vmess://" + b64("just some text"),
+		"shadowrocket no method": "vmess://" + b64(testUUID+"@a.example.com:443"),
+		"shadowrocket bad port":  "vmess://" + b64("auto:"+testUUID+"@a.example.com:http"),
+		"shadowrocket bad query": "vmess://" + b64("auto:"+testUUID+"@a.example.com:443") + "?remarks=%zz",
+		"quantumult too short":   "vmess://" + b64("name = vmess, a.example.com, 443"),
+		"quantumult bad port":    "vmess://" + b64(`name = vmess, a.example.com, https, auto, "`+testUUID+`"`),
+		"aead url missing port":  "vmess://" + testUUID + "@a.example.com",
+		"aead url bad port":      "vmess://" + testUUID + "@[2001:db8::1]:http",
+		"aead url bad query":     "vmess://" + testUUID + "@a.example.com:443?type=%zz",
+		"empty":                  "vmess://",
+	}
+	for name, link := range tests {
+		t.Run(name, func(t *testing.T) {
+			proxy, stats := parseLine(t, ParseVMess, link)
+			if proxy != nil {
+				t.Errorf("parsed %+v", *proxy)
+			}
+			if stats.VMessFail != 1 || stats.TotalFail != 1 || stats.VMessSuccess != 0 {
+				t.Errorf("stats = %+v, want one VMess failure", stats)
+			}
+		})
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"log"
+	"strings"
+
+	"subs-check-custom/types"
+)
+
+// Pipeline stage names
+const (
+	StageTCP      = "tcp"
+	StageLatency  = "latency"
+	StageDownload = "download"
+	StageUpload   = "upload"
+	StageUDP      = "udp"
+	StageUnlock   = "unlock"
+	StageEgressIP = "egress-ip"
+)
+
+// testStageFunc runs one stage over nodes. Nodes that fail are dropped when
+// dropFailed is set and kept with their failure recorded otherwise.
+type testStageFunc func(cfg types.Config, nodes []types.Proxy, sched *testScheduler, testLogger *log.Logger, dropFailed bool) []types.Proxy
+
+// pipelineStage describes a stage the pipeline can run
+type pipelineStage struct {
+	run        testStageFunc
+	dropFailed bool // Default when the stage entry does not set drop-failed
+}
+
+var pipelineStages = map[string]pipelineStage{
+	StageTCP:      {tcpTest, true},
+	StageLatency:  {latencyTest, true},
+	StageDownload: {speedTest, false},
+	StageUpload:   {uploadTest, true},
+	StageUDP:      {udpTest, false},
+	StageUnlock:   {unlockTest, true},
+	StageEgressIP: {egressTest, false},
+}
+
+// runPipeline runs the stages in order, each on the nodes the previous one passed on
+func runPipeline(cfg types.Config, nodes []types.Proxy, stages []types.TestStage, sched *testScheduler, testLogger *log.Logger) []types.Proxy {
+	collapseAt := egressCollapsePoint(stages)
+	for i, stage := range stages {
+		name := strings.ToLower(stage.Stage)
+		ps, ok := pipelineStages[name]
+		if !ok {
+			testLogger.Printf("Pipeline - Skipping unknown stage %d: %q", i+1, stage.Stage)
+			continue
+		}
+		dropFailed := ps.dropFailed
+		if stage.DropFailed != nil {
+			dropFailed = *stage.DropFailed
+		}
+		testLogger.Printf("Pipeline - Stage %d/%d: %s on %d nodes (drop failed: %t)", i+1, len(stages), name, len(nodes), dropFailed)
+		nodes = ps.run(stageConfig(cfg, name, stage), nodes, sched, testLogger, dropFailed)
+		if i == collapseAt {
+			nodes = collapseByEgress(nodes, testLogger)
+		}
+	}
+	return nodes
+}
+
+// egressCollapsePoint returns the index of the stage after which nodes sharing
+// an exit IP are collapsed: the last download stage following the egress-ip
+// stage, so the fastest node per exit survives, or the egress-ip stage itself.
+// It returns -1 when there is no egress-ip stage.
+func egressCollapsePoint(stages []types.TestStage) int {
+	point := -1
+	for i, stage := range stages {
+		switch strings.ToLower(stage.Stage) {
+		case StageEgressIP:
+			if point < 0 {
+				point = i
+			}
+		case StageDownload:
+			if point >= 0 {
+				point = i
+			}
+		}
+	}
+	return point
+}
+
+// stageConfig overlays the parameters set on a stage onto a copy of cfg
+func stageConfig(cfg types.Config, name string, stage types.TestStage) types.Config {
+	if stage.Timeout > 0 {
+		cfg.Timeout = stage.Timeout
+	}
+	switch name {
+	case StageTCP:
+		if stage.URL != "" {
+			cfg.TCPTestURL = stage.URL
+		}
+		if stage.MaxLatency > 0 {
+			cfg.TCPTestMaxSpeed = stage.MaxLatency
+		}
+	case StageLatency:
+		if stage.URL != "" {
+			cfg.LatencyURL = stage.URL
+		}
+		if stage.Probes > 0 {
+			cfg.LatencyProbes = stage.Probes
+		}
+		if cfg.LatencyProbes <= 0 {
+			cfg.LatencyProbes = 3 // Listing the stage means probing is wanted
+		}
+		if stage.Max != nil {
+			cfg.LatencyMax = stage.Max
+		}
+	case StageDownload:
+		if stage.URL != "" {
+			cfg.SpeedTestURL = stage.URL
+		}
+		if stage.MinSpeed > 0 {
+			cfg.MinSpeed = stage.MinSpeed
+		}
+		if stage.Duration > 0 {
+			cfg.SpeedTestDuration = stage.Duration
+		}
+		if stage.Warmup > 0 {
+			cfg.SpeedTestWarmup = stage.Warmup
+		}
+		if stage.Streams > 0 {
+			cfg.SpeedTestStreams = stage.Streams
+		}
+	case StageUpload:
+		if stage.URL != "" {
+			cfg.UploadTestURL = stage.URL
+		}
+		if stage.MinSpeed > 0 {
+			cfg.MinUploadSpeed = stage.MinSpeed
+		}
+		if stage.Size > 0 {
+			cfg.UploadSize = stage.Size
+		}
+	case StageUDP:
+		if stage.URL != "" {
+			cfg.UDPTestTarget = stage.URL
+		}
+		if stage.Mode != "" {
+			cfg.UDPTestMode = stage.Mode
+		}
+	case StageUnlock:
+		if stage.Checks != nil {
+			cfg.UnlockChecks = stage.Checks
+		}
+		if stage.Require != nil {
+			cfg.UnlockRequire = stage.Require
+		}
+	case StageEgressIP:
+		if stage.URL != "" {
+			cfg.EgressEchoURL = stage.URL
+		}
+	}
+	return cfg
+}
+
+// menuPipeline translates a choice from the interactive test menu into stages.
+// The TCP test is followed by the optional stages enabled in the top-level settings.
+func menuPipeline(cfg types.Config, choice string) []types.TestStage {
+	var tcp []types.TestStage
+	if choice == "1" || choice == "3" || choice == "5" {
+		tcp = append(tcp, types.TestStage{Stage: StageTCP})
+		if cfg.LatencyProbes > 0 {
+			tcp = append(tcp, types.TestStage{Stage: StageLatency})
+		}
+		if cfg.EgressIPCheck || cfg.DedupStrategy == DedupEgressIP {
+			tcp = append(tcp, types.TestStage{Stage: StageEgressIP})
+		}
+		if cfg.UDPTest {
+			tcp = append(tcp, types.TestStage{Stage: StageUDP})
+		}
+		if len(cfg.UnlockChecks) > 0 {
+			tcp = append(tcp, types.TestStage{Stage: StageUnlock})
+		}
+	}
+
+	switch choice {
+	case "1":
+		return tcp
+	case "2":
+		return []types.TestStage{{Stage: StageDownload}}
+	case "3":
+		return append(tcp, types.TestStage{Stage: StageDownload})
+	case "4":
+		return []types.TestStage{{Stage: StageUpload}}
+	case "5":
+		return append(tcp, types.TestStage{Stage: StageDownload}, types.TestStage{Stage: StageUpload})
+	}
+	return nil
+}
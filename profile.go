@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"text/template"
+
+	"gopkg.in/yaml.v3"
+
+	"subs-check-custom/clash"
+	"subs-check-custom/types"
+)
+
+// defaultProfileTemplate is a complete mihomo config with a selector over
+// automatic, per-country and per-subscription groups and Loyalsoldier's rule sets
+const defaultProfileTemplate = `mixed-port: 7890
+allow-lan: false
+mode: rule
+log-level: info
+proxies: {{json .Proxies}}
+proxy-groups:
+  - name: 🚀 Proxy
+    type: select
+    proxies:
+      - ♻️ Auto
+      - 🔯 Fallback
+      - ⚖️ Balance
+{{- range .Countries}}
+      - {{json .Name}}
+{{- end}}
+{{- range .Sources}}
+      - {{json .Name}}
+{{- end}}
+{{- range .Names}}
+      - {{json .}}
+{{- end}}
+      - DIRECT
+  - name: ♻️ Auto
+    type: url-test
+    url: {{json .TestURL}}
+    interval: 300
+    tolerance: 50
+    proxies: {{json .Names}}
+  - name: 🔯 Fallback
+    type: fallback
+    url: {{json .TestURL}}
+    interval: 300
+    proxies: {{json .Names}}
+  - name: ⚖️ Balance
+    type: load-balance
+    strategy: consistent-hashing
+    url: {{json .TestURL}}
+    interval: 300
+    proxies: {{json .Names}}
+{{- range .Countries}}
+  - name: {{json .Name}}
+    type: url-test
+    url: {{json $.TestURL}}
+    interval: 300
+    tolerance: 50
+    proxies: {{json .Proxies}}
+{{- end}}
+{{- range .Sources}}
+  - name: {{json .Name}}
+    type: select
+    proxies: {{json .Proxies}}
+{{- end}}
+  - name: 🐟 Final
+    type: select
+    proxies: [🚀 Proxy, DIRECT]
+rule-providers:
+{{- range $name, $behavior := dict "reject" "domain" "private" "domain" "direct" "domain" "proxy" "domain" "gfw" "domain" "cncidr" "ipcidr" "lancidr" "ipcidr" "telegramcidr" "ipcidr"}}
+  {{$name}}:
+    type: http
+    behavior: {{$behavior}}
+    url: https://cdn.jsdelivr.net/gh/Loyalsoldier/clash-rules@release/{{$name}}.txt
+    path: ./ruleset/{{$name}}.yaml
+    interval: 86400
+{{- end}}
+rules:
+  - RULE-SET,private,DIRECT
+  - RULE-SET,reject,REJECT
+  - RULE-SET,proxy,🚀 Proxy
+  - RULE-SET,gfw,🚀 Proxy
+  - RULE-SET,direct,DIRECT
+  - RULE-SET,telegramcidr,🚀 Proxy,no-resolve
+  - RULE-SET,lancidr,DIRECT,no-resolve
+  - RULE-SET,cncidr,DIRECT,no-resolve
+  - GEOIP,LAN,DIRECT,no-resolve
+  - GEOIP,CN,DIRECT,no-resolve
+  - MATCH,🐟 Final
+`
+
+// profileGroup is a named set of proxies offered to a profile template
+type profileGroup struct {
+	Name    string   // Group name, e.g. "🇭🇰 HK" or "📦 example.com"
+	Key     string   // Country code or subscription host
+	Proxies []string // Proxy names in output order
+}
+
+// profileData is what a profile template sees
+type profileData struct {
+	Proxies   []clash.Proxy  // Proxies mihomo can carry, in output order
+	Names     []string       // Their names
+	Countries []profileGroup // Proxies by GeoIP country, sorted by code
+	Sources   []profileGroup // Proxies by subscription, sorted by host
+	TestURL   string         // URL for url-test, fallback and load-balance groups
+}
+
+// renderProfile renders a complete mihomo config for nodes from the template
+// file at path, or the built-in template when path is empty
+func renderProfile(cfg types.Config, path string, nodes []types.Proxy) ([]byte, error) {
+	text := defaultProfileTemplate
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("read profile template: %w", err)
+		}
+		text = string(data)
+	}
+	tmpl, err := template.New("profile").Funcs(template.FuncMap{
+		"json": toJSON,
+		"dict": dict,
+		"flag": countryFlag,
+	}).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("parse profile template: %w", err)
+	}
+
+	data := profileData{TestURL: cfg.LatencyURL}
+	if data.TestURL == "" {
+		data.TestURL = defaultLatencyURL
+	}
+	countries := make(map[string][]string)
+	sources := make(map[string][]string)
+	for _, node := range nodes {
+		proxy, err := clash.Convert(node)
+		if err != nil {
+			continue // mihomo cannot carry it, as the clash output logs
+		}
+		data.Proxies = append(data.Proxies, proxy)
+		data.Names = append(data.Names, node.Name)
+		if country := node.Geo().Country; country != "" {
+			countries[country] = append(countries[country], node.Name)
+		}
+		if node.Source != "" {
+			sources[node.Source] = append(sources[node.Source], node.Name)
+		}
+	}
+	data.Countries = profileGroups(countries, func(key string) string { return countryFlag(key) + " " + key })
+	data.Sources = profileGroups(sources, func(key string) string { return "📦 " + key })
+
+	if len(data.Proxies) == 0 {
+		return nil, fmt.Errorf("no nodes mihomo can carry")
+	}
+
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, data); err != nil {
+		return nil, fmt.Errorf("render profile template: %w", err)
+	}
+	profile, err := reformatYAML(out.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("profile is not valid YAML: %w", err)
+	}
+	return profile, nil
+}
+
+// profileGroups turns proxy names by key into groups sorted by key
+func profileGroups(byKey map[string][]string, name func(key string) string) []profileGroup {
+	groups := make([]profileGroup, 0, len(byKey))
+	for key, proxies := range byKey {
+		groups = append(groups, profileGroup{Name: name(key), Key: key, Proxies: proxies})
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].Key < groups[j].Key })
+	return groups
+}
+
+// toJSON renders v as JSON, which is also a valid YAML flow value
+func toJSON(v any) (string, error) {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return "", err
+	}
+	return string(bytes.TrimSpace(b.Bytes())), nil
+}
+
+// dict builds a map from alternating keys and values
+func dict(pairs ...any) (map[string]any, error) {
+	if len(pairs)%2 != 0 {
+		return nil, fmt.Errorf("dict: odd number of arguments")
+	}
+	m := make(map[string]any, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		key, ok := pairs[i].(string)
+		if !ok {
+			return nil, fmt.Errorf("dict: key %v is not a string", pairs[i])
+		}
+		m[key] = pairs[i+1]
+	}
+	return m, nil
+}
+
+// reformatYAML parses a rendered profile and writes it back in block style so
+// that JSON values from templates read like the rest of the file
+func reformatYAML(data []byte) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	var blockStyle func(n *yaml.Node)
+	blockStyle = func(n *yaml.Node) {
+		n.Style &^= yaml.FlowStyle | yaml.DoubleQuotedStyle
+		for _, child := range n.Content {
+			blockStyle(child)
+		}
+	}
+	blockStyle(&doc)
+
+	var out bytes.Buffer
+	enc := yaml.NewEncoder(&out)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+
+	"subs-check-custom/types"
+)
+
+// mihomoProfile is the part of a rendered profile the tests look at
+type mihomoProfile struct {
+	Proxies []struct {
+		Name string `yaml:"name"`
+		Type string `yaml:"type"`
+	} `yaml:"proxies"`
+	ProxyGroups []struct {
+		Name     string   `yaml:"name"`
+		Type     string   `yaml:"type"`
+		URL      string   `yaml:"url"`
+		Strategy string   `yaml:"strategy"`
+		Proxies  []string `yaml:"proxies"`
+	} `yaml:"proxy-groups"`
+	RuleProviders map[string]struct {
+		Type     string `yaml:"type"`
+		Behavior string `yaml:"behavior"`
+		URL      string `yaml:"url"`
+		Path     string `yaml:"path"`
+	} `yaml:"rule-providers"`
+	Rules []string `yaml:"rules"`
+}
+
+func profileNodes() []types.Proxy {
+	return []types.Proxy{
+		{Name: "hk-1", Type: "ss", Server: "hk1.example.com", Port: 8388, Cipher: "aes-256-gcm", Password: "x",
+			Source: "a.example.com", ServerGeo: types.GeoInfo{Country: "HK"}},
+		{Name: "jp-1", Type: "trojan", Server: "jp1.example.com", Port: 443, Password: "x",
+			Source: "b.example.com", EgressGeo: types.GeoInfo{Country: "JP"}, ServerGeo: types.GeoInfo{Country: "US"}},
+		{Name: "hk-2", Type: "vless", Server: "hk2.example.com", Port: 443, UUID: "b831381d-6324-4d53-ad4f-8cda48b30811",
+			Source: "a.example.com", ServerGeo: types.GeoInfo{Country: "HK"}},
+		{Name: "nowhere", Type: "ss", Server: "x.example.com", Port: 8388, Cipher: "aes-256-gcm", Password: "x"},
+		{Name: "tuic", Type: "tuic", Server: "t.example.com", Port: 443, Source: "a.example.com"}, // mihomo output leaves it out
+	}
+}
+
+func TestRenderDefaultProfile(t *testing.T) {
+	data, err := renderProfile(types.Config{LatencyURL: "https://cp.cloudflare.com/generate_204"}, "", profileNodes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var profile mihomoProfile
+	if err := yaml.Unmarshal(data, &profile); err != nil {
+		t.Fatalf("profile is not YAML: %v\n%s", err, data)
+	}
+
+	var proxies []string
+	for _, p := range profile.Proxies {
+		proxies = append(proxies, p.Name)
+	}
+	names := []string{"hk-1", "jp-1", "hk-2", "nowhere"}
+	if !reflect.DeepEqual(proxies, names) {
+		t.Errorf("proxies = %v, want %v", proxies, names)
+	}
+
+	type group struct {
+		typ     string
+		proxies []string
+	}
+	want := map[string]group{
+		"🚀 Proxy":         {"select", []string{"♻️ Auto", "🔯 Fallback", "⚖️ Balance", "🇭🇰 HK", "🇯🇵 JP", "📦 a.example.com", "📦 b.example.com", "hk-1", "jp-1", "hk-2", "nowhere", "DIRECT"}},
+		"♻️ Auto":         {"url-test", names},
+		"🔯 Fallback":      {"fallback", names},
+		"⚖️ Balance":      {"load-balance", names},
+		"🇭🇰 HK":           {"url-test", []string{"hk-1", "hk-2"}},
+		"🇯🇵 JP":           {"url-test", []string{"jp-1"}},
+		"📦 a.example.com": {"select", []string{"hk-1", "hk-2"}},
+		"📦 b.example.com": {"select", []string{"jp-1"}},
+		"🐟 Final":         {"select", []string{"🚀 Proxy", "DIRECT"}},
+	}
+	if len(profile.ProxyGroups) != len(want) {
+		t.Errorf("got %d proxy groups, want %d", len(profile.ProxyGroups), len(want))
+	}
+	for _, g := range profile.ProxyGroups {
+		w, ok := want[g.Name]
+		if !ok {
+			t.Errorf("unexpected group %q", g.Name)
+			continue
+		}
+		if g.Type != w.typ || !reflect.DeepEqual(g.Proxies, w.proxies) {
+			t.Errorf("group %q = %s %v, want %s %v", g.Name, g.Type, g.Proxies, w.typ, w.proxies)
+		}
+		if g.Type == "url-test" || g.Type == "fallback" || g.Type == "load-balance" {
+			if g.URL != "https://cp.cloudflare.com/generate_204" {
+				t.Errorf("group %q tests %q", g.Name, g.URL)
+			}
+		}
+		if g.Type == "load-balance" && g.Strategy != "consistent-hashing" {
+			t.Errorf("group %q strategy = %q", g.Name, g.Strategy)
+		}
+	}
+
+	behaviors := map[string]string{"reject": "domain", "private": "domain", "direct": "domain", "proxy": "domain", "gfw": "domain",
+		"cncidr": "ipcidr", "lancidr": "ipcidr", "telegramcidr": "ipcidr"}
+	if len(profile.RuleProviders) != len(behaviors) {
+		t.Errorf("got %d rule providers, want %d", len(profile.RuleProviders), len(behaviors))
+	}
+	for name, behavior := range behaviors {
+		p, ok := profile.RuleProviders[name]
+		if !ok {
+			t.Errorf("missing rule provider %s", name)
+			continue
+		}
+		if p.Type != "http" || p.Behavior != behavior || !strings.HasSuffix(p.URL, "/"+name+".txt") || p.Path != "./ruleset/"+name+".yaml" {
+			t.Errorf("rule provider %s = %+v", name, p)
+		}
+	}
+	if len(profile.Rules) == 0 || profile.Rules[len(profile.Rules)-1] != "MATCH,🐟 Final" {
+		t.Errorf("rules = %v, want them to end with MATCH,🐟 Final", profile.Rules)
+	}
+}
+
+func TestRenderProfileTemplate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "profile.tmpl")
+	text := "proxies: {{json .Proxies}}\nproxy-groups:\n{{- range .Countries}}\n  - name: {{json .Name}}\n    type: select\n    proxies: {{json .Proxies}}\n{{- end}}\n"
+	if err := os.WriteFile(path, []byte(text), 0644); err != nil {
+		t.Fatal(err)
+	}
+	data, err := renderProfile(types.Config{}, path, profileNodes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var profile mihomoProfile
+	if err := yaml.Unmarshal(data, &profile); err != nil {
+		t.Fatal(err)
+	}
+	if len(profile.Proxies) != 4 || len(profile.ProxyGroups) != 2 || profile.ProxyGroups[0].Name != "🇭🇰 HK" {
+		t.Errorf("rendered %s", data)
+	}
+	if strings.Contains(string(data), "[") || strings.Contains(string(data), "{") {
+		t.Errorf("JSON values were not turned into block style:\n%s", data)
+	}
+
+	if _, err := renderProfile(types.Config{}, path, []types.Proxy{{Name: "tuic", Type: "tuic"}}); err == nil {
+		t.Error("rendered a profile without usable nodes")
+	}
+	os.WriteFile(path, []byte("proxies: {{.Missing"), 0644)
+	if _, err := renderProfile(types.Config{}, path, profileNodes()); err == nil {
+		t.Error("rendered a broken template")
+	}
+}
+
+func TestConfiguredOutputsSingleFileKeys(t *testing.T) {
+	cfg := types.Config{ProfileFile: "profile.yaml", ProfileTemplate: "profile.tmpl",
+		SingBoxFile: "singbox.json", SingBoxFull: true, SingBoxMultiplex: "h2mux"}
+	want := []types.OutputConfig{
+		{Format: OutputProfile, Path: "profile.yaml", Options: map[string]string{"template": "profile.tmpl"}},
+		{Format: OutputSingBox, Path: "singbox.json", Options: map[string]string{"full": "true", "multiplex": "h2mux"}},
+	}
+	if got := configuredOutputs(cfg); !reflect.DeepEqual(got, want) {
+		t.Errorf("configuredOutputs = %+v, want %+v", got, want)
+	}
+
+	cfg.Outputs = []types.OutputConfig{{Format: OutputURIs, Path: "nodes.txt"}}
+	if got := configuredOutputs(cfg); !reflect.DeepEqual(got, cfg.Outputs) {
+		t.Errorf("configuredOutputs = %+v, want the outputs list only", got)
+	}
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"subs-check-custom/types"
+)
+
+// defaultRenameTemplate names a node after its country when GeoIP knows it and
+// after the subscription otherwise, followed by its test results
+const defaultRenameTemplate = `{{if .Country}}{{flag .Country}} {{.Country}} {{index}}{{else}}{{.Name}}{{end}}` +
+	`{{if or .Speed .Upload}} |{{if .Speed}} ⬇️ {{mbps .Speed}}{{end}}{{if .Upload}} ⬆️ {{mbps .Upload}}{{end}}{{end}}` +
+	`{{if .Unlock}} | 🔓 {{.Unlock}}{{end}}`
+
+// Strategies for nodes whose new names collide, selectable with rename-collision
+const (
+	RenameSuffix = "suffix" // Append (2), (3), ... to later nodes
+	RenameDrop   = "drop"   // Keep only the first node with a name
+)
+
+// renameData is what a rename template sees of a node
+type renameData struct {
+	Name      string            // Subscription name before the first |, after rename-rules
+	Original  string            // Subscription name as parsed
+	Type      string            // Protocol
+	Server    string            // Server address
+	Port      int               // Server port
+	Network   string            // Transport
+	Country   string            // ISO code from GeoIP, egress first
+	Org       string            // ASN organisation from GeoIP
+	ASN       uint              // Autonomous system number from GeoIP
+	EgressIP  string            // Exit IP when checked
+	Latency   int64             // ms
+	Speed     float64           // Download, KB/s
+	PeakSpeed float64           // Fastest download interval, KB/s
+	Upload    float64           // Upload, KB/s
+	UDP       bool              // Whether the UDP test passed
+	Unlock    string            // Unlocked services, e.g. "disney netflix-US"
+	Tags      map[string]string // Unlock verdicts keyed by service
+	Score     float64           // Weighted score
+	Stability float64           // From the history database
+	Uptime    float64           // From the history database
+	Rank      int               // 1-based position in the output
+}
+
+// nodeRenamer renders node names from cfg.RenameTemplate after applying cfg.RenameRules
+type nodeRenamer struct {
+	tmpl     *template.Template
+	rules    []renameRule
+	collide  string
+	current  *renameData
+	counters map[string]int // Nodes accepted so far per country, for {{index}}
+	names    map[string]int
+}
+
+type renameRule struct {
+	re      *regexp.Regexp
+	replace string
+}
+
+func newNodeRenamer(cfg types.Config, logger *log.Logger) *nodeRenamer {
+	r := &nodeRenamer{
+		collide:  strings.ToLower(cfg.RenameCollision),
+		counters: make(map[string]int),
+		names:    make(map[string]int),
+	}
+	switch r.collide {
+	case RenameSuffix, RenameDrop:
+	case "":
+		r.collide = RenameSuffix
+	default:
+		logger.Printf("Unknown rename collision strategy '%s', defaulting to %s", cfg.RenameCollision, RenameSuffix)
+		r.collide = RenameSuffix
+	}
+
+	for _, rule := range cfg.RenameRules {
+		re, err := regexp.Compile(rule.Match)
+		if err != nil {
+			logger.Printf("Ignoring rename rule %q: %v", rule.Match, err)
+			continue
+		}
+		r.rules = append(r.rules, renameRule{re, rule.Replace})
+	}
+
+	funcs := template.FuncMap{
+		"flag": countryFlag,
+		"mbps": func(kbps float64) string { return fmt.Sprintf("%.1fMB/s", kbps/1024) },
+		// index without arguments numbers nodes within their country and
+		// otherwise behaves like the builtin
+		"index": func(args ...any) (any, error) {
+			if len(args) == 0 {
+				return r.counters[r.current.Country] + 1, nil
+			}
+			return indexValue(args[0], args[1:]...)
+		},
+		"upper": strings.ToUpper,
+		"lower": strings.ToLower,
+		"trim":  strings.TrimSpace,
+	}
+
+	text := cfg.RenameTemplate
+	if text == "" {
+		text = defaultRenameTemplate
+	}
+	tmpl, err := template.New("rename").Funcs(funcs).Parse(text)
+	if err != nil {
+		logger.Printf("Invalid rename template (%v), using the default", err)
+		tmpl = template.Must(template.New("rename").Funcs(funcs).Parse(defaultRenameTemplate))
+	}
+	r.tmpl = tmpl
+	return r
+}
+
+// rename returns the node's new name, or false when the collision strategy drops
+// it. rank is the node's position among the nodes accepted so far plus one.
+func (r *nodeRenamer) rename(node types.Proxy, rank int, logger *log.Logger) (string, bool) {
+	name := strings.TrimSpace(strings.Split(node.Name, "|")[0])
+	for _, rule := range r.rules {
+		name = rule.re.ReplaceAllString(name, rule.replace)
+	}
+	name = strings.TrimSpace(name)
+
+	geo := node.Geo()
+	data := &renameData{
+		Name:      name,
+		Original:  node.Name,
+		Type:      node.Type,
+		Server:    node.Server,
+		Port:      node.Port,
+		Network:   node.Network,
+		Country:   geo.Country,
+		Org:       geo.Org,
+		ASN:       geo.ASN,
+		EgressIP:  node.EgressIP,
+		Latency:   node.Latency,
+		Speed:     node.Speed,
+		PeakSpeed: node.PeakSpeed,
+		Upload:    node.UploadSpeed,
+		UDP:       node.UDP != nil && *node.UDP,
+		Unlock:    unlockLabel(node.Tags),
+		Tags:      node.Tags,
+		Score:     node.Score,
+		Rank:      rank,
+	}
+	if node.History != nil {
+		data.Stability = node.History.Stability
+		data.Uptime = node.History.Uptime
+	}
+	r.current = data
+
+	var b strings.Builder
+	if err := r.tmpl.Execute(&b, data); err != nil {
+		logger.Printf("Rename template failed for %s: %v", node.Name, err)
+		b.Reset()
+		b.WriteString(name)
+	}
+	newName := strings.Join(strings.Fields(b.String()), " ")
+	if newName == "" {
+		newName = name
+	}
+
+	r.names[newName]++
+	if r.names[newName] > 1 {
+		if r.collide == RenameDrop {
+			logger.Printf("Rename: dropped %s, its name %s is taken", node.Name, newName)
+			return "", false
+		}
+		for n := r.names[newName]; ; n++ {
+			candidate := fmt.Sprintf("%s (%d)", newName, n)
+			if r.names[candidate] == 0 {
+				r.names[candidate]++
+				newName = candidate
+				break
+			}
+		}
+	}
+	// Only accepted nodes take a number, so dropped ones leave no gaps
+	r.counters[data.Country]++
+	return newName, true
+}
+
+// renameNodes gives every node its output name. All output formats are written
+// from the returned nodes, so they share the same names.
+func renameNodes(cfg types.Config, nodes []types.Proxy, logger *log.Logger) []types.Proxy {
+	r := newNodeRenamer(cfg, logger)
+	renamed := make([]types.Proxy, 0, len(nodes))
+	for _, node := range nodes {
+		name, ok := r.rename(node, len(renamed)+1, logger)
+		if !ok {
+			continue
+		}
+		node.Name = name
+		renamed = append(renamed, node)
+	}
+	return renamed
+}
+
+// indexValue is the builtin template index for maps, slices and arrays
+func indexValue(item any, keys ...any) (any, error) {
+	v := reflect.ValueOf(item)
+	for _, key := range keys {
+		k := reflect.ValueOf(key)
+		switch v.Kind() {
+		case reflect.Map:
+			if !k.IsValid() || !k.Type().AssignableTo(v.Type().Key()) {
+				return nil, fmt.Errorf("index: key %v does not fit %s", key, v.Type())
+			}
+			if v = v.MapIndex(k); !v.IsValid() {
+				return "", nil
+			}
+		case reflect.Slice, reflect.Array, reflect.String:
+			if !k.CanInt() {
+				return nil, fmt.Errorf("index: %v is not an integer", key)
+			}
+			i := int(k.Int())
+			if i < 0 || i >= v.Len() {
+				return nil, fmt.Errorf("index: %d out of range", i)
+			}
+			v = v.Index(i)
+		default:
+			return nil, fmt.Errorf("index: cannot index %s", v.Kind())
+		}
+	}
+	return v.Interface(), nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"io"
+	"log"
+	"testing"
+
+	"subs-check-custom/types"
+)
+
+func TestRenameNodesNumbersAcceptedOnly(t *testing.T) {
+	cfg := types.Config{
+		RenameTemplate:  `{{if eq .Name "dup"}}dup{{else}}{{.Country}} {{index}} #{{.Rank}}{{end}}`,
+		RenameCollision: RenameDrop,
+	}
+	hk := types.GeoInfo{Country: "HK"}
+	nodes := []types.Proxy{
+		{Name: "dup", ServerGeo: hk},
+		{Name: "dup", ServerGeo: hk},
+		{Name: "a", ServerGeo: hk},
+		{Name: "b", ServerGeo: types.GeoInfo{Country: "JP"}},
+		{Name: "c", ServerGeo: hk},
+	}
+
+	renamed := renameNodes(cfg, nodes, log.New(io.Discard, "", 0))
+	var got []string
+	for _, node := range renamed {
+		got = append(got, node.Name)
+	}
+	want := []string{"dup", "HK 2 #2", "JP 1 #3", "HK 3 #4"}
+	if len(got) != len(want) {
+		t.Fatalf("renamed to %q, want %q", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("node %d renamed to %q, want %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestRenameNodesSuffix(t *testing.T) {
+	cfg := types.Config{RenameTemplate: `{{.Name}}`}
+	nodes := []types.Proxy{{Name: "a | 1MB/s"}, {Name: "a"}, {Name: "a (2)"}, {Name: "a"}}
+
+	renamed := renameNodes(cfg, nodes, log.New(io.Discard, "", 0))
+	want := []string{"a", "a (2)", "a (2) (2)", "a (3)"}
+	if len(renamed) != len(want) {
+		t.Fatalf("got %d nodes, want %d", len(renamed), len(want))
+	}
+	for i, node := range renamed {
+		if node.Name != want[i] {
+			t.Errorf("node %d renamed to %q, want %q", i, node.Name, want[i])
+		}
+	}
+}
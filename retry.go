@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"subs-check-custom/types"
+)
+
+// Failure classes recorded on nodes that fail a test
+const (
+	FailureDNS        = "dns"
+	FailureRefused    = "refused"
+	FailureTLS        = "tls"
+	FailureTimeout    = "timeout"
+	FailureHTTPStatus = "http-status"
+	FailureHandshake  = "handshake" // The proxy accepted the connection but the node dropped it
+	FailureThreshold  = "threshold" // The test worked but the result missed a configured limit
+	FailureOther      = "other"
+)
+
+// errThreshold marks failures caused by a result outside a configured limit
+var errThreshold = errors.New("threshold not met")
+
+// statusError is returned when a test URL answers with an unexpected HTTP status
+type statusError struct {
+	code int
+}
+
+func (e statusError) Error() string {
+	return fmt.Sprintf("unexpected status: %d", e.code)
+}
+
+// classifyFailure maps a test error to one of the failure classes
+func classifyFailure(err error) string {
+	var status statusError
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	var certErr *tls.CertificateVerificationError
+	var alert tls.AlertError
+	var recordErr tls.RecordHeaderError
+	var authorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+	switch {
+	case err == nil:
+		return ""
+	case errors.As(err, &status):
+		return FailureHTTPStatus
+	case errors.Is(err, errThreshold):
+		return FailureThreshold
+	case errors.As(err, &dnsErr):
+		return FailureDNS
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return FailureTimeout
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return FailureRefused
+	case errors.As(err, &certErr), errors.As(err, &alert), errors.As(err, &recordErr),
+		errors.As(err, &authorityErr), errors.As(err, &hostnameErr), errors.As(err, &invalidErr),
+		strings.Contains(err.Error(), "tls: "):
+		return FailureTLS
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF), errors.Is(err, syscall.ECONNRESET),
+		errors.Is(err, syscall.EPIPE), strings.Contains(err.Error(), "socks"):
+		return FailureHandshake
+	}
+	return FailureOther
+}
+
+// retryPolicy decides how often and how fast failed test attempts are repeated
+type retryPolicy struct {
+	attempts   int
+	backoff    time.Duration
+	maxBackoff time.Duration
+	jitter     float64
+	retryOn    map[string]bool
+}
+
+// defaultRetryOn lists the classes retried when the config names none. Status
+// codes, TLS errors and missed thresholds are unlikely to change on a retry.
+var defaultRetryOn = []string{FailureDNS, FailureRefused, FailureTimeout, FailureHandshake}
+
+func newRetryPolicy(cfg types.RetryConfig) retryPolicy {
+	p := retryPolicy{
+		attempts:   cfg.Attempts,
+		backoff:    time.Duration(cfg.Backoff) * time.Millisecond,
+		maxBackoff: time.Duration(cfg.MaxBackoff) * time.Millisecond,
+		jitter:     cfg.Jitter,
+		retryOn:    make(map[string]bool),
+	}
+	if p.attempts < 1 {
+		p.attempts = 1
+	}
+	retryOn := cfg.RetryOn
+	if len(retryOn) == 0 {
+		retryOn = defaultRetryOn
+	}
+	for _, class := range retryOn {
+		p.retryOn[strings.ToLower(class)] = true
+	}
+	return p
+}
+
+// do calls fn until it succeeds, fails with a class that is not retried or
+// runs out of attempts, and returns the last error. attempt counts from 1.
+func (p retryPolicy) do(fn func(attempt int) error) error {
+	var err error
+	for attempt := 1; attempt <= p.attempts; attempt++ {
+		if attempt > 1 {
+			time.Sleep(p.delay(attempt - 1))
+		}
+		if err = fn(attempt); err == nil || !p.retryOn[classifyFailure(err)] {
+			return err
+		}
+	}
+	return err
+}
+
+// delay returns the wait before the given retry: backoff doubled per retry,
+// capped at maxBackoff and spread by up to ±jitter of itself
+func (p retryPolicy) delay(retry int) time.Duration {
+	d := float64(p.backoff) * math.Pow(2, float64(retry-1))
+	if p.maxBackoff > 0 && d > float64(p.maxBackoff) {
+		d = float64(p.maxBackoff)
+	}
+	if p.jitter > 0 {
+		d += d * p.jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(d)
+}
+
+// formatFailures renders failure counts per class, most frequent first
+func formatFailures(failures map[string]int) string {
+	if len(failures) == 0 {
+		return "none"
+	}
+	classes := make([]string, 0, len(failures))
+	for class := range failures {
+		classes = append(classes, class)
+	}
+	sort.Slice(classes, func(i, j int) bool {
+		if failures[classes[i]] == failures[classes[j]] {
+			return classes[i] < classes[j]
+		}
+		return failures[classes[i]] > failures[classes[j]]
+	})
+	parts := make([]string, len(classes))
+	for i, class := range classes {
+		parts[i] = fmt.Sprintf("%s %d", class, failures[class])
+	}
+	return strings.Join(parts, ", ")
+}
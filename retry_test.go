@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"subs-check-custom/types"
+)
+
+func TestClassifyFailure(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"nil", nil, ""},
+		{"status", fmt.Errorf("download: %w", statusError{503}), FailureHTTPStatus},
+		{"threshold", fmt.Errorf("%w: 120 KB/s", errThreshold), FailureThreshold},
+		{"dns", &net.DNSError{Err: "no such host", Name: "nope.example.com", IsNotFound: true}, FailureDNS},
+		{"deadline", fmt.Errorf("probe: %w", context.DeadlineExceeded), FailureTimeout},
+		{"net timeout", &net.OpError{Op: "dial", Err: os.ErrDeadlineExceeded}, FailureTimeout},
+		{"refused", &net.OpError{Op: "dial", Err: &os.SyscallError{Syscall: "connect", Err: syscall.ECONNREFUSED}}, FailureRefused},
+		{"unknown authority", x509.UnknownAuthorityError{}, FailureTLS},
+		{"tls alert", tls.AlertError(40), FailureTLS},
+		{"tls text", errors.New("remote error: tls: handshake failure"), FailureTLS},
+		{"eof", fmt.Errorf("read: %w", io.EOF), FailureHandshake},
+		{"reset", &net.OpError{Op: "read", Err: syscall.ECONNRESET}, FailureHandshake},
+		{"socks", errors.New("socks connect tcp 127.0.0.1:1080->example.com:443: unknown error general SOCKS server failure"), FailureHandshake},
+		{"other", errors.New("something else"), FailureOther},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classifyFailure(tt.err); got != tt.want {
+				t.Errorf("classifyFailure(%v) = %q, want %q", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      types.RetryConfig
+		retry    int
+		min, max time.Duration
+	}{
+		{"first retry", types.RetryConfig{Backoff: 100}, 1, 100 * time.Millisecond, 100 * time.Millisecond},
+		{"doubled", types.RetryConfig{Backoff: 100}, 3, 400 * time.Millisecond, 400 * time.Millisecond},
+		{"capped", types.RetryConfig{Backoff: 100, MaxBackoff: 250}, 3, 250 * time.Millisecond, 250 * time.Millisecond},
+		{"jitter", types.RetryConfig{Backoff: 100, Jitter: 0.2}, 2, 160 * time.Millisecond, 240 * time.Millisecond},
+		{"jitter after cap", types.RetryConfig{Backoff: 100, MaxBackoff: 150, Jitter: 0.5}, 4, 75 * time.Millisecond, 225 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newRetryPolicy(tt.cfg)
+			for i := 0; i < 200; i++ {
+				if d := p.delay(tt.retry); d < tt.min || d > tt.max {
+					t.Fatalf("delay(%d) = %v, want between %v and %v", tt.retry, d, tt.min, tt.max)
+				}
+			}
+		})
+	}
+}
+
+func TestRetryPolicyDo(t *testing.T) {
+	timeout := fmt.Errorf("probe: %w", context.DeadlineExceeded)
+	tests := []struct {
+		name      string
+		cfg       types.RetryConfig
+		errs      []error // Result of each attempt, nil once they run out
+		wantCalls int
+		wantErr   error
+	}{
+		{"success first", types.RetryConfig{Attempts: 3}, nil, 1, nil},
+		{"retried until success", types.RetryConfig{Attempts: 3}, []error{timeout, timeout}, 3, nil},
+		{"out of attempts", types.RetryConfig{Attempts: 2}, []error{timeout, timeout, timeout}, 2, timeout},
+		{"zero attempts tries once", types.RetryConfig{}, []error{timeout}, 1, timeout},
+		{"class not retried", types.RetryConfig{Attempts: 3}, []error{statusError{404}}, 1, statusError{404}},
+		{"configured class", types.RetryConfig{Attempts: 3, RetryOn: []string{"HTTP-Status"}}, []error{statusError{502}}, 2, nil},
+		{"configured classes replace the default", types.RetryConfig{Attempts: 3, RetryOn: []string{FailureHTTPStatus}}, []error{timeout}, 1, timeout},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newRetryPolicy(tt.cfg)
+			calls := 0
+			err := p.do(func(attempt int) error {
+				calls++
+				if attempt != calls {
+					t.Errorf("attempt = %d on call %d", attempt, calls)
+				}
+				if attempt <= len(tt.errs) {
+					return tt.errs[attempt-1]
+				}
+				return nil
+			})
+			if calls != tt.wantCalls {
+				t.Errorf("fn called %d times, want %d", calls, tt.wantCalls)
+			}
+			if !errors.Is(err, tt.wantErr) && err != tt.wantErr {
+				t.Errorf("do() = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
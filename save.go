@@ -1,116 +1,44 @@
 package main
 
 import (
-	"bufio"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"log"
+	"net"
 	"net/url"
 	"os"
-	"sort"
+	"strconv"
 	"strings"
 
-	"gopkg.in/yaml.v3"
-
 	"subs-check-custom/types"
 )
 
 func saveResults(cfg types.Config, nodes []types.Proxy) {
-	sort.Slice(nodes, func(i, j int) bool {
-		if nodes[i].Speed == nodes[j].Speed {
-			return nodes[i].Name < nodes[j].Name
-		}
-		return nodes[i].Speed > nodes[j].Speed
-	})
-
-	seen := make(map[string]bool)
-	uniqueNodes := []types.Proxy{}
-	nameCount := make(map[string]int)
+	sortNodes(nodes, cfg.SortBy, cfg.ScoreWeights, log.Default())
 
+	candidates := make([]types.Proxy, 0, len(nodes))
 	for _, node := range nodes {
 		if node.Server == "" && node.Type != "No usable nodes" {
 			continue
 		}
-
-		key := fmt.Sprintf("%s:%d", node.Server, node.Port)
-		if seen[key] && node.Type != "No usable nodes" {
-			continue
-		}
-		seen[key] = true
-
-		originalName := node.Name
-		parts := strings.Split(originalName, "|")
-		if len(parts) < 1 {
-			continue
-		}
-		namePart := strings.TrimSpace(parts[0])
-		speedMBs := node.Speed / 1024
-		speedPart := fmt.Sprintf("⬇️ %.1fMB/s", speedMBs)
-
-		flagCountry := namePart
-		flagCountry = strings.ReplaceAll(flagCountry, "CloudFlare节点", "")
-		flagCountry = strings.ReplaceAll(flagCountry, "特殊", "")
-		flagCountry = strings.TrimSpace(flagCountry)
-
-		counter := ""
-		nameWords := strings.Split(flagCountry, " ")
-		if len(nameWords) > 1 {
-			lastWord := nameWords[len(nameWords)-1]
-			if (strings.HasPrefix(lastWord, "(") && strings.HasSuffix(lastWord, ")")) || strings.HasPrefix(lastWord, "0") {
-				counter = lastWord
-				flagCountry = strings.Join(nameWords[:len(nameWords)-1], " ")
-			}
-		}
-
-		newName := flagCountry
-		if counter != "" {
-			newName += " " + counter
-		}
-		if node.Speed > 0 {
-			newName += " | " + speedPart
-		}
-
-		nameCount[newName]++
-		if nameCount[newName] > 1 {
-			newCounter := fmt.Sprintf("(%d)", nameCount[newName]-1)
-			newName = flagCountry + " " + newCounter
-			if node.Speed > 0 {
-				newName += " | " + speedPart
-			}
-		}
-
-		proxy := node
-		proxy.Name = newName
-		proxy.Speed = 0
-		uniqueNodes = append(uniqueNodes, proxy)
+		candidates = append(candidates, node)
 	}
+	// Nodes are sorted best first, so the best duplicate survives
+	candidates = dedupNodes(candidates, cfg.DedupStrategy, nil)
 
-	err := saveUniqueNodesToTxt(uniqueNodes, cfg.UniqueNodesFile)
-	if err != nil {
-		log.Printf("Failed to save unique nodes to %s: %v", cfg.UniqueNodesFile, err)
-	} else {
-		log.Printf("Saved unique nodes to %s", cfg.UniqueNodesFile)
-	}
-
-	data := map[string][]types.Proxy{"proxies": uniqueNodes}
-	if len(uniqueNodes) == 0 {
-		data["proxies"] = []types.Proxy{{Name: "No usable nodes | ⬇️ 0.0MB/s"}}
-	}
+	uniqueNodes := renameNodes(cfg, candidates, log.Default())
 
-	file, err := yaml.Marshal(data)
-	if err != nil {
-		log.Printf("Marshal failed: %v", err)
-		return
-	}
-	savePath := cfg.AllOutputFile
-	if err := os.WriteFile(savePath, file, 0644); err != nil {
-		log.Printf("Local save failed: %v", err)
-	} else {
-		log.Printf("Saved locally to %s", savePath)
-	}
+	writeOutputs(cfg, uniqueNodes)
 
 	logMessage := fmt.Sprintf("Number of remaining nodes after removing duplicates: %d\n", len(uniqueNodes))
+	for i, node := range uniqueNodes {
+		logMessage += fmt.Sprintf("Rank %d: %s", i+1, node.Name)
+		if len(cfg.ScoreWeights) > 0 {
+			logMessage += fmt.Sprintf(" (score %.3f)", node.Score)
+		}
+		logMessage += "\n"
+	}
 	f, err := os.OpenFile("parsingLog.txt", os.O_APPEND|os.O_WRONLY|os.O_CREATE, 0644)
 	if err != nil {
 		log.Printf("Failed to open parsingLog.txt for appending: %v", err)
@@ -128,16 +56,12 @@ func saveResults(cfg types.Config, nodes []types.Proxy) {
 	}
 }
 
-func saveUniqueNodesToTxt(nodes []types.Proxy, filename string) error {
-	file, err := os.Create(filename)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	writer := bufio.NewWriter(file)
+// nodeURIs returns the share links of nodes, skipping types without one
+func nodeURIs(nodes []types.Proxy) []string {
+	var uris []string
 	for _, node := range nodes {
 		var uri string
+		hostPort := net.JoinHostPort(node.Server, strconv.Itoa(node.Port))
 		switch node.Type {
 		case "vmess":
 			vmessConfig := types.VMessConfig{
@@ -153,13 +77,14 @@ func saveUniqueNodesToTxt(nodes []types.Proxy, filename string) error {
 				Tls:  map[bool]string{true: "tls", false: ""}[node.TLS],
 				Sni:  node.SNI,
 			}
-			if node.Network == "ws" && len(node.WSOpts) > 0 {
-				if path, ok := node.WSOpts["path"]; ok {
+			if node.Network == "grpc" {
+				// v2rayN carries the gRPC service name in path
+				vmessConfig.Path = node.GrpcOpts["grpc-service-name"]
+			} else {
+				if path := sharePath(node); path != "" {
 					vmessConfig.Path = path
 				}
-				if host, ok := node.WSOpts["host"]; ok {
-					vmessConfig.Host = host
-				}
+				vmessConfig.Host = node.WSOpts["host"]
 			}
 			jsonData, err := json.Marshal(vmessConfig)
 			if err != nil {
@@ -171,21 +96,23 @@ func saveUniqueNodesToTxt(nodes []types.Proxy, filename string) error {
 
 		case "ss":
 			auth := base64.StdEncoding.EncodeToString([]byte(node.Cipher + ":" + node.Password))
-			uri = fmt.Sprintf("ss://%s@%s:%d#%s", auth, node.Server, node.Port, url.QueryEscape(node.Name))
+			uri = fmt.Sprintf("ss://%s@%s#%s", auth, hostPort, escapeFragment(node.Name))
 
-		case "trojan":
-			query := url.Values{}
-			if node.SNI != "" {
-				query.Set("sni", node.SNI)
+		case "ssr":
+			params := url.Values{}
+			params.Set("remarks", base64.RawURLEncoding.EncodeToString([]byte(node.Name)))
+			if node.ObfsParam != "" {
+				params.Set("obfsparam", base64.RawURLEncoding.EncodeToString([]byte(node.ObfsParam)))
 			}
-			if node.SkipCertVerify {
-				query.Set("allowInsecure", "1")
+			if node.ProtocolParam != "" {
+				params.Set("protoparam", base64.RawURLEncoding.EncodeToString([]byte(node.ProtocolParam)))
 			}
-			queryStr := ""
-			if len(query) > 0 {
-				queryStr = "?" + query.Encode()
-			}
-			uri = fmt.Sprintf("trojan://%s@%s:%d%s#%s", node.Password, node.Server, node.Port, queryStr, url.QueryEscape(node.Name))
+			body := fmt.Sprintf("%s:%d:%s:%s:%s:%s/?%s", node.Server, node.Port, node.Protocol, node.Cipher, node.Obfs,
+				base64.RawURLEncoding.EncodeToString([]byte(node.Password)), params.Encode())
+			uri = "ssr://" + base64.RawURLEncoding.EncodeToString([]byte(body))
+
+		case "trojan":
+			uri = fmt.Sprintf("trojan://%s@%s%s#%s", node.Password, hostPort, encodeQuery(shareQuery(node)), escapeFragment(node.Name))
 
 		case "hysteria2":
 			query := url.Values{}
@@ -201,50 +128,83 @@ func saveUniqueNodesToTxt(nodes []types.Proxy, filename string) error {
 			if node.ObfsPassword != "" {
 				query.Set("obfs-password", node.ObfsPassword)
 			}
-			queryStr := ""
-			if len(query) > 0 {
-				queryStr = "?" + query.Encode()
-			}
-			uri = fmt.Sprintf("hysteria2://%s@%s:%d%s#%s", node.Password, node.Server, node.Port, queryStr, url.QueryEscape(node.Name))
+			uri = fmt.Sprintf("hysteria2://%s@%s%s#%s", node.Password, hostPort, encodeQuery(query), escapeFragment(node.Name))
 
 		case "vless":
-			query := url.Values{}
-			if node.SNI != "" {
-				query.Set("sni", node.SNI)
-			}
-			if node.SkipCertVerify {
-				query.Set("allowInsecure", "1")
-			}
-			if node.Network != "" {
-				query.Set("type", node.Network)
+			query := shareQuery(node)
+			if node.Flow != "" {
+				query.Set("flow", node.Flow)
 			}
-			if node.TLS {
-				query.Set("security", "tls")
-			}
-			if node.Network == "ws" && len(node.WSOpts) > 0 {
-				if path, ok := node.WSOpts["path"]; ok {
-					query.Set("path", path)
-				}
-				if host, ok := node.WSOpts["host"]; ok {
-					query.Set("host", host)
-				}
-			}
-			queryStr := ""
-			if len(query) > 0 {
-				queryStr = "?" + query.Encode()
-			}
-			uri = fmt.Sprintf("vless://%s@%s:%d%s#%s", node.UUID, node.Server, node.Port, queryStr, url.QueryEscape(node.Name))
+			uri = fmt.Sprintf("vless://%s@%s%s#%s", node.UUID, hostPort, encodeQuery(query), escapeFragment(node.Name))
 
 		default:
 			continue
 		}
 
 		if uri != "" {
-			_, err = writer.WriteString(uri + "\r\n")
-			if err != nil {
-				return err
-			}
+			uris = append(uris, uri)
+		}
+	}
+	return uris
+}
+
+// shareQuery returns the transport and TLS parameters vless and trojan links share
+func shareQuery(node types.Proxy) url.Values {
+	query := url.Values{}
+	if node.Network != "" {
+		query.Set("type", node.Network)
+	}
+	if key := node.RealityOpts["public-key"]; key != "" {
+		query.Set("security", "reality")
+		query.Set("pbk", key)
+		if shortID := node.RealityOpts["short-id"]; shortID != "" {
+			query.Set("sid", shortID)
 		}
+	} else if node.TLS {
+		query.Set("security", "tls")
+	}
+	if node.SNI != "" {
+		query.Set("sni", node.SNI)
+	}
+	if node.ClientFingerprint != "" {
+		query.Set("fp", node.ClientFingerprint)
+	}
+	if len(node.ALPN) > 0 {
+		query.Set("alpn", strings.Join(node.ALPN, ","))
+	}
+	if node.SkipCertVerify {
+		query.Set("allowInsecure", "1")
+	}
+	if host := node.WSOpts["host"]; host != "" {
+		query.Set("host", host)
+	}
+	if path := sharePath(node); path != "" {
+		query.Set("path", path)
+	}
+	if serviceName := node.GrpcOpts["grpc-service-name"]; serviceName != "" {
+		query.Set("serviceName", serviceName)
 	}
-	return writer.Flush()
-}
\ No newline at end of file
+	return query
+}
+
+// sharePath returns the transport path, which parsers keep in WSOpts or Path
+func sharePath(node types.Proxy) string {
+	if path := node.WSOpts["path"]; path != "" {
+		return path
+	}
+	return node.Path
+}
+
+// encodeQuery renders query with its leading '?', or nothing when it is empty
+func encodeQuery(query url.Values) string {
+	if len(query) == 0 {
+		return ""
+	}
+	return "?" + query.Encode()
+}
+
+// escapeFragment percent-encodes a node name for the #fragment of a share link.
+// Spaces become %20 rather than '+', which parsers would keep literally.
+func escapeFragment(name string) string {
+	return strings.ReplaceAll(url.QueryEscape(name), "+", "%20")
+}
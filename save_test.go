@@ -0,0 +1,177 @@
+package main
+
+import (
+	"io"
+	"log"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"subs-check-custom/parsers"
+	"subs-check-custom/types"
+)
+
+// parseLink parses one share link the way fetchNodes does
+func parseLink(t *testing.T, link string) *types.Proxy {
+	t.Helper()
+	logger := log.New(io.Discard, "", 0)
+	stats := &types.ProxyStats{}
+	parse := map[string]func(string, int, *log.Logger, *types.ProxyStats) *types.Proxy{
+		"vmess://":     parsers.ParseVMess,
+		"ssr://":       parsers.ParseSSR,
+		"ss://":        parsers.ParseSS,
+		"trojan://":    parsers.ParseTrojan,
+		"hysteria2://": parsers.ParseHysteria2,
+		"vless://":     parsers.ParseVLess,
+	}
+	for prefix, fn := range parse {
+		if strings.HasPrefix(link, prefix) {
+			return fn(link, 1, logger, stats)
+		}
+	}
+	t.Fatalf("no parser for %s", link)
+	return nil
+}
+
+func TestNodeURIsRoundTrip(t *testing.T) {
+	log.SetOutput(io.Discard) // The SS parser logs every step
+	defer log.SetOutput(os.Stderr)
+
+	tests := []struct {
+		name  string
+		link  string
+		check func(t *testing.T, node *types.Proxy) // Fields the link must carry
+		want  []string                              // Parameters the exported link must contain
+	}{
+		{
+			name: "vless reality vision",
+			link: "vless://b831381d-6324-4d53-ad4f-8cda48b30811@reality.example.com:443?type=tcp&security=reality&sni=www.microsoft.com&fp=chrome" +
+				"&pbk=Z84J2IelR9ch3k8VtlVhhs5ycBUlXA7wHBWcBrjqnAw&sid=6ba85179e30d4fc2&flow=xtls-rprx-vision#JP%20Reality",
+			check: func(t *testing.T, node *types.Proxy) {
+				if node.RealityOpts["public-key"] == "" || node.Flow != "xtls-rprx-vision" || node.Name != "JP Reality" {
+					t.Errorf("parsed %+v", node)
+				}
+			},
+			want: []string{"security=reality", "pbk=", "sid=6ba85179e30d4fc2", "fp=chrome", "flow=xtls-rprx-vision", "#JP%20Reality"},
+		},
+		{
+			name: "vless grpc",
+			link: "vless://b831381d-6324-4d53-ad4f-8cda48b30811@grpc.example.com:443?type=grpc&security=tls&serviceName=tunnel&alpn=h2%2Chttp%2F1.1&sni=grpc.example.com#grpc",
+			check: func(t *testing.T, node *types.Proxy) {
+				if node.GrpcOpts["grpc-service-name"] != "tunnel" || len(node.ALPN) != 2 {
+					t.Errorf("parsed %+v", node)
+				}
+			},
+			want: []string{"type=grpc", "serviceName=tunnel", "alpn=h2%2Chttp%2F1.1", "security=tls"},
+		},
+		{
+			name: "vless ws on ipv6",
+			link: "vless://b831381d-6324-4d53-ad4f-8cda48b30811@[2001:db8::1]:8443?type=ws&security=tls&path=%2Fws%3Fed%3D2048&host=cdn.example.com#a+b%20c",
+			check: func(t *testing.T, node *types.Proxy) {
+				if node.Server != "2001:db8::1" || node.WSOpts["path"] != "/ws?ed=2048" || node.Name != "a b c" {
+					t.Errorf("parsed %+v", node)
+				}
+			},
+			want: []string{"@[2001:db8::1]:8443", "host=cdn.example.com", "path=%2Fws%3Fed%3D2048"},
+		},
+		{
+			name: "trojan ws",
+			link: "trojan://secret@trojan.example.com:443?type=ws&path=%2Ftj&host=cdn.example.com&sni=cdn.example.com&fp=firefox#Trojan%20WS",
+			check: func(t *testing.T, node *types.Proxy) {
+				if node.Network != "ws" || node.WSOpts["path"] != "/tj" || node.WSOpts["host"] != "cdn.example.com" || node.Name != "Trojan WS" {
+					t.Errorf("parsed %+v", node)
+				}
+			},
+			want: []string{"type=ws", "path=%2Ftj", "host=cdn.example.com", "fp=firefox"},
+		},
+		{
+			name: "trojan grpc without query name",
+			link: "trojan://secret@trojan.example.com:443?type=grpc&serviceName=svc",
+			check: func(t *testing.T, node *types.Proxy) {
+				if node.GrpcOpts["grpc-service-name"] != "svc" {
+					t.Errorf("parsed %+v", node)
+				}
+			},
+			want: []string{"type=grpc", "serviceName=svc"},
+		},
+		{
+			name: "trojan plain",
+			link: "trojan://secret@trojan.example.com:443#plain",
+			check: func(t *testing.T, node *types.Proxy) {
+				if node.Port != 443 || node.Name != "plain" {
+					t.Errorf("parsed %+v", node)
+				}
+			},
+		},
+		{
+			name: "vmess grpc",
+			link: "vmess://eyJ2IjoiMiIsInBzIjoiZ3JwYyIsImFkZCI6InZtLmV4YW1wbGUuY29tIiwicG9ydCI6IjQ0MyIsImlkIjoiYjgzMTM4MWQtNjMyNC00ZDUzLWFkNGYtOGNkYTQ4YjMwODExIiwiYWlkIjoiMCIsIm5ldCI6ImdycGMiLCJwYXRoIjoidHVubmVsIiwidGxzIjoidGxzIn0=",
+			check: func(t *testing.T, node *types.Proxy) {
+				if node.GrpcOpts["grpc-service-name"] != "tunnel" {
+					t.Errorf("parsed %+v", node)
+				}
+			},
+		},
+		{
+			name: "vmess ws tls",
+			link: "vmess://eyJ2IjoiMiIsInBzIjoid3MiLCJhZGQiOiJ2bS5leGFtcGxlLmNvbSIsInBvcnQiOjQ0MywiaWQiOiJiODMxMzgxZC02MzI0LTRkNTMtYWQ0Zi04Y2RhNDhiMzA4MTEiLCJhaWQiOjAsIm5ldCI6IndzIiwicGF0aCI6Ii93cyIsImhvc3QiOiJjZG4uZXhhbXBsZS5jb20iLCJ0bHMiOiJ0bHMiLCJzbmkiOiJjZG4uZXhhbXBsZS5jb20ifQ==",
+			check: func(t *testing.T, node *types.Proxy) {
+				if node.WSOpts["path"] != "/ws" || node.WSOpts["host"] != "cdn.example.com" || !node.TLS {
+					t.Errorf("parsed %+v", node)
+				}
+			},
+		},
+		{
+			name: "ss",
+			link: "ss://YWVzLTI1Ni1nY206c2VjcmV0@ss.example.com:8388#SS%20Node",
+			check: func(t *testing.T, node *types.Proxy) {
+				if node.Cipher != "aes-256-gcm" || node.Password != "secret" || node.Name != "SS Node" {
+					t.Errorf("parsed %+v", node)
+				}
+			},
+		},
+		{
+			name: "ssr",
+			link: "ssr://c3NyLmV4YW1wbGUuY29tOjg5ODk6YXV0aF9hZXMxMjhfbWQ1OmFlcy0yNTYtY2ZiOnRsczEuMl90aWNrZXRfYXV0aDpjMlZqY21WMC8_b2Jmc3BhcmFtPVkyUnVMbVY0WVcxd2JHVXVZMjl0JnByb3RvcGFyYW09TVRwaFltTSZyZW1hcmtzPVUxTlNJRTV2WkdV",
+			check: func(t *testing.T, node *types.Proxy) {
+				if node.ObfsParam != "cdn.example.com" || node.ProtocolParam != "1:abc" || node.Name != "SSR Node" {
+					t.Errorf("parsed %+v", node)
+				}
+			},
+		},
+		{
+			name: "hysteria2",
+			link: "hysteria2://secret@hy2.example.com:443?sni=hy2.example.com&obfs=salamander&obfs-password=p%40ss&insecure=1#HY2",
+			check: func(t *testing.T, node *types.Proxy) {
+				if node.ObfsPassword != "p@ss" || !node.SkipCertVerify || node.Name != "HY2" {
+					t.Errorf("parsed %+v", node)
+				}
+			},
+			want: []string{"obfs=salamander", "insecure=1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			node := parseLink(t, tt.link)
+			if node == nil {
+				t.Fatalf("failed to parse %s", tt.link)
+			}
+			tt.check(t, node)
+
+			uris := nodeURIs([]types.Proxy{*node})
+			if len(uris) != 1 {
+				t.Fatalf("nodeURIs() = %v, want one link", uris)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(uris[0], want) {
+					t.Errorf("%s does not contain %s", uris[0], want)
+				}
+			}
+			again := parseLink(t, uris[0])
+			if !reflect.DeepEqual(again, node) {
+				t.Errorf("re-parsed %s as\n%+v\nwant\n%+v", uris[0], again, node)
+			}
+		})
+	}
+}
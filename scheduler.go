@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net"
+	"strings"
+	"sync"
+
+	"golang.org/x/time/rate"
+
+	"subs-check-custom/types"
+)
+
+// testResult is the outcome of one node in one test stage
+type testResult int
+
+const (
+	testPassed testResult = iota
+	testFailed
+	testSkipped // The node cannot be tested by Xray and is passed through untouched
+)
+
+// progressFunc receives progress updates as nodes complete a stage
+type progressFunc func(stage string, completed, total int)
+
+// testJob tests one node through proxyAddr, which the scheduler has already
+// bound to the node. It may record measurements on node and returns why the node failed.
+type testJob func(index int, node *types.Proxy, proxyAddr string) error
+
+// testCounts summarises a stage once every node has completed
+type testCounts struct {
+	Total    int
+	Passed   int
+	Failed   int
+	Skipped  int
+	Failures map[string]int // Failed nodes per failure class
+}
+
+// testScheduler runs test stages on a bounded pool of Xray slots with a global
+// rate limit and a cap on concurrent tests against the same server IP
+type testScheduler struct {
+	xray       xrayBackend
+	limiter    *rate.Limiter // nil when unlimited
+	perHost    int           // 0 when unlimited
+	progress   progressFunc
+	testLogger *log.Logger
+	retry      retryPolicy
+
+	hostMutex sync.Mutex
+	hostSems  map[string]chan struct{}
+	resolved  map[string]string
+
+	outcomeMutex sync.Mutex
+	failures     map[string]int                // Failures per class across every stage
+	outcomes     map[string]*types.TestFailure // First failure per node fingerprint, nil if it passed every stage
+}
+
+func newTestScheduler(cfg types.Config, xray xrayBackend, progress progressFunc, testLogger *log.Logger) *testScheduler {
+	s := &testScheduler{
+		xray:       xray,
+		perHost:    cfg.PerHostLimit,
+		progress:   progress,
+		testLogger: testLogger,
+		retry:      newRetryPolicy(cfg.Retry),
+		hostSems:   make(map[string]chan struct{}),
+		resolved:   make(map[string]string),
+		failures:   make(map[string]int),
+		outcomes:   make(map[string]*types.TestFailure),
+	}
+	if cfg.RateLimit > 0 {
+		s.limiter = rate.NewLimiter(rate.Limit(cfg.RateLimit), 1)
+	}
+	return s
+}
+
+// run executes job for every node and returns the per-node results, indexed like nodes.
+// Untestable nodes are skipped without using a slot. Failed nodes have their
+// classified failure recorded.
+func (s *testScheduler) run(stage string, nodes []types.Proxy, job testJob) ([]testResult, testCounts) {
+	results := make([]testResult, len(nodes))
+	counts := testCounts{Total: len(nodes), Failures: make(map[string]int)}
+	var countMutex sync.Mutex
+	completed := 0
+	finish := func(i int, result testResult, err error) {
+		countMutex.Lock()
+		defer countMutex.Unlock()
+		results[i] = result
+		switch result {
+		case testPassed:
+			counts.Passed++
+			s.recordOutcome(nodes[i], nil)
+		case testFailed:
+			class := classifyFailure(err)
+			failure := &types.TestFailure{Stage: stage, Class: class, Error: err.Error()}
+			// Nodes kept with drop-failed off carry their first failure through later
+			// stages, matching the outcome recorded in the history
+			if nodes[i].Failure == nil {
+				nodes[i].Failure = failure
+			}
+			counts.Failed++
+			counts.Failures[class]++
+			s.recordOutcome(nodes[i], failure)
+		case testSkipped:
+			counts.Skipped++
+		}
+		completed++
+		if s.progress != nil {
+			s.progress(stage, completed, len(nodes))
+		}
+	}
+
+	// A fixed pool of workers, one per slot, so at most len(slots) nodes
+	// resolve hosts, wait on the rate limit or run tests at any time
+	slots := newSlotPool(s.xray)
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < cap(slots); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				s.runOne(i, &nodes[i], slots, job, finish)
+			}
+		}()
+	}
+	for i := range nodes {
+		if !xrayTestable(nodes[i]) {
+			s.testLogger.Printf("Node %d: Skipped - %s nodes are not supported by the Xray tester (%s)", i, nodes[i].Type, nodes[i].Name)
+			finish(i, testSkipped, nil)
+			continue
+		}
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+	return results, counts
+}
+
+// runOne tests one node. It takes a slot, then the node's host semaphore, then
+// a rate limit token, so tokens are only spent right before the node is switched in.
+func (s *testScheduler) runOne(i int, node *types.Proxy, slots slotPool, job testJob, finish func(int, testResult, error)) {
+	slot := <-slots
+	defer func() { slots <- slot }()
+	release := s.acquireHost(node.Server)
+	defer release()
+	if s.limiter != nil {
+		s.limiter.Wait(context.Background())
+	}
+
+	if err := s.xray.SwitchNode(slot, *node); err != nil {
+		s.testLogger.Printf("Node %d: Fail - Failed to switch to %s:%d (%s): %v", i, node.Server, node.Port, node.Name, err)
+		finish(i, testFailed, err)
+		return
+	}
+	if err := job(i, node, s.xray.ProxyAddr(slot)); err != nil {
+		finish(i, testFailed, err)
+	} else {
+		finish(i, testPassed, nil)
+	}
+}
+
+// recordOutcome notes that node passed a stage or failed it with failure. A
+// node keeps the first failure it had across stages.
+func (s *testScheduler) recordOutcome(node types.Proxy, failure *types.TestFailure) {
+	s.outcomeMutex.Lock()
+	defer s.outcomeMutex.Unlock()
+	key := node.Fingerprint()
+	if previous, ok := s.outcomes[key]; !ok || previous == nil {
+		s.outcomes[key] = failure
+	}
+	if failure != nil {
+		s.failures[failure.Class]++
+	}
+}
+
+// nodeOutcomes returns the outcome of every node tested so far, keyed by fingerprint
+func (s *testScheduler) nodeOutcomes() map[string]*types.TestFailure {
+	s.outcomeMutex.Lock()
+	defer s.outcomeMutex.Unlock()
+	outcomes := make(map[string]*types.TestFailure, len(s.outcomes))
+	for key, failure := range s.outcomes {
+		outcomes[key] = failure
+	}
+	return outcomes
+}
+
+// failureSummary returns the failure counts per class across every stage run so far
+func (s *testScheduler) failureSummary() map[string]int {
+	s.outcomeMutex.Lock()
+	defer s.outcomeMutex.Unlock()
+	summary := make(map[string]int, len(s.failures))
+	for class, n := range s.failures {
+		summary[class] = n
+	}
+	return summary
+}
+
+// acquireHost blocks until fewer than perHost tests run against the server's IP
+// and returns the matching release function
+func (s *testScheduler) acquireHost(server string) func() {
+	if s.perHost <= 0 {
+		return func() {}
+	}
+	key := s.hostKey(server)
+	s.hostMutex.Lock()
+	sem, ok := s.hostSems[key]
+	if !ok {
+		sem = make(chan struct{}, s.perHost)
+		s.hostSems[key] = sem
+	}
+	s.hostMutex.Unlock()
+
+	sem <- struct{}{}
+	return func() { <-sem }
+}
+
+// hostKey resolves server to its first IP so that different names of one
+// machine share a limit. Unresolvable names are limited on their own.
+func (s *testScheduler) hostKey(server string) string {
+	server = strings.ToLower(server)
+	s.hostMutex.Lock()
+	key, ok := s.resolved[server]
+	s.hostMutex.Unlock()
+	if ok {
+		return key
+	}
+
+	key = server
+	if net.ParseIP(server) == nil {
+		if ips, err := net.LookupIP(server); err == nil && len(ips) > 0 {
+			key = ips[0].String()
+		}
+	}
+	s.hostMutex.Lock()
+	s.resolved[server] = key
+	s.hostMutex.Unlock()
+	return key
+}
+
+// keepResults returns the nodes whose result is passed or skipped, in their original
+// order. Unless dropFailed is set, failed nodes are kept with their failure recorded.
+func keepResults(nodes []types.Proxy, results []testResult, dropFailed bool) []types.Proxy {
+	if !dropFailed {
+		return nodes
+	}
+	kept := make([]types.Proxy, 0, len(nodes))
+	for i, node := range nodes {
+		if results[i] != testFailed {
+			kept = append(kept, node)
+		}
+	}
+	return kept
+}
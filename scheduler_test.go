@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"subs-check-custom/types"
+)
+
+// fakeXray records when nodes are switched in and how many slots are busy
+type fakeXray struct {
+	slots    int
+	mu       sync.Mutex
+	busy     int
+	maxBusy  int
+	switched []time.Time
+}
+
+func (f *fakeXray) Slots() int                { return f.slots }
+func (f *fakeXray) ProxyAddr(slot int) string { return fmt.Sprintf("127.0.0.1:%d", 20000+slot) }
+func (f *fakeXray) Close() error              { return nil }
+
+func (f *fakeXray) SwitchNode(slot int, node types.Proxy) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.switched = append(f.switched, time.Now())
+	return nil
+}
+
+func (f *fakeXray) enter() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.busy++
+	if f.busy > f.maxBusy {
+		f.maxBusy = f.busy
+	}
+}
+
+func (f *fakeXray) leave() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.busy--
+}
+
+func testNodesFor(n int) []types.Proxy {
+	nodes := make([]types.Proxy, n)
+	for i := range nodes {
+		nodes[i] = types.Proxy{Name: fmt.Sprintf("node-%d", i), Type: "trojan", Server: fmt.Sprintf("192.0.2.%d", i+1), Port: 443, Password: "x"}
+	}
+	return nodes
+}
+
+func TestSchedulerBoundsConcurrency(t *testing.T) {
+	xray := &fakeXray{slots: 3}
+	sched := newTestScheduler(types.Config{}, xray, nil, log.New(io.Discard, "", 0))
+	nodes := testNodesFor(20)
+	nodes = append(nodes, types.Proxy{Name: "ssr", Type: "ssr", Server: "192.0.2.200", Port: 1})
+
+	results, counts := sched.run("test", nodes, func(index int, node *types.Proxy, proxyAddr string) error {
+		xray.enter()
+		defer xray.leave()
+		time.Sleep(10 * time.Millisecond)
+		if index%5 == 0 {
+			return fmt.Errorf("%w: too slow", errThreshold)
+		}
+		return nil
+	})
+
+	if xray.maxBusy > xray.slots {
+		t.Errorf("%d tests ran at once, want at most %d", xray.maxBusy, xray.slots)
+	}
+	if counts.Passed != 16 || counts.Failed != 4 || counts.Skipped != 1 {
+		t.Errorf("counts = %+v, want 16 passed, 4 failed, 1 skipped", counts)
+	}
+	if results[20] != testSkipped || nodes[0].Failure == nil || nodes[0].Failure.Class != FailureThreshold {
+		t.Errorf("unexpected results %v, failure %+v", results, nodes[0].Failure)
+	}
+}
+
+func TestSchedulerRateLimitDoesNotBurst(t *testing.T) {
+	xray := &fakeXray{slots: 2}
+	sched := newTestScheduler(types.Config{RateLimit: 20}, xray, nil, log.New(io.Discard, "", 0))
+
+	sched.run("test", testNodesFor(8), func(index int, node *types.Proxy, proxyAddr string) error {
+		time.Sleep(100 * time.Millisecond) // Longer than the 50ms between tokens
+		return nil
+	})
+
+	sort.Slice(xray.switched, func(i, j int) bool { return xray.switched[i].Before(xray.switched[j]) })
+	for i := 1; i < len(xray.switched); i++ {
+		if gap := xray.switched[i].Sub(xray.switched[i-1]); gap < 40*time.Millisecond {
+			t.Errorf("switch %d followed the previous one after %v, want about 50ms or more", i, gap)
+		}
+	}
+}
+
+func TestSchedulerKeepsFirstFailure(t *testing.T) {
+	xray := &fakeXray{slots: 1}
+	sched := newTestScheduler(types.Config{}, xray, nil, log.New(io.Discard, "", 0))
+	nodes := testNodesFor(1)
+
+	sched.run("Speed test", nodes, func(index int, node *types.Proxy, proxyAddr string) error {
+		return fmt.Errorf("%w: too slow", errThreshold)
+	})
+	sched.run("Upload test", nodes, func(index int, node *types.Proxy, proxyAddr string) error { return nil })
+	sched.run("UDP test", nodes, func(index int, node *types.Proxy, proxyAddr string) error { return errors.New("no reply") })
+
+	if nodes[0].Failure == nil || nodes[0].Failure.Stage != "Speed test" {
+		t.Errorf("Failure = %+v, want the speed test failure kept", nodes[0].Failure)
+	}
+	if outcome := sched.nodeOutcomes()[nodes[0].Fingerprint()]; outcome == nil || outcome.Stage != "Speed test" {
+		t.Errorf("outcome = %+v, want the speed test failure", outcome)
+	}
+}
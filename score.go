@@ -0,0 +1,152 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"subs-check-custom/types"
+)
+
+const defaultSortBy = "-speed, latency, -stability, name"
+
+// sortKey is one field of a sort spec
+type sortKey struct {
+	field string
+	desc  bool
+	text  bool
+}
+
+// parseSortSpec parses a comma-separated list of fields, each optionally
+// prefixed with - for descending or + for ascending order
+func parseSortSpec(spec string) ([]sortKey, error) {
+	var keys []sortKey
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		key := sortKey{}
+		switch part[0] {
+		case '-':
+			key.desc = true
+			part = part[1:]
+		case '+':
+			part = part[1:]
+		}
+		key.field = strings.ToLower(strings.TrimSpace(part))
+		switch {
+		case isStringField(key.field):
+			key.text = true
+		case isNumberField(key.field):
+		default:
+			return nil, fmt.Errorf("unknown sort field %q", key.field)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// sortNodes scores nodes with weights, then orders them by spec. Nodes missing a
+// field sort after those that have it, whatever the direction. Ties fall back to the name.
+func sortNodes(nodes []types.Proxy, spec string, weights map[string]float64, logger *log.Logger) {
+	if len(weights) > 0 {
+		scoreNodes(nodes, weights, logger)
+	}
+	if spec == "" {
+		spec = defaultSortBy
+	}
+	keys, err := parseSortSpec(spec)
+	if err != nil {
+		logger.Printf("Invalid sort-by %q (%v), using %q", spec, err, defaultSortBy)
+		keys, _ = parseSortSpec(defaultSortBy)
+	}
+
+	sort.SliceStable(nodes, func(i, j int) bool {
+		for _, key := range keys {
+			if c := compareField(nodes[i], nodes[j], key); c != 0 {
+				return c < 0
+			}
+		}
+		return nodes[i].Name < nodes[j].Name
+	})
+}
+
+// compareField returns -1 if a sorts before b on key, 1 if after and 0 if equal
+func compareField(a, b types.Proxy, key sortKey) int {
+	var c int
+	var okA, okB bool
+	if key.text {
+		var va, vb string
+		va, okA = nodeString(a, key.field)
+		vb, okB = nodeString(b, key.field)
+		c = strings.Compare(va, vb)
+	} else {
+		var va, vb float64
+		va, okA = nodeNumber(a, key.field)
+		vb, okB = nodeNumber(b, key.field)
+		switch {
+		case va < vb:
+			c = -1
+		case va > vb:
+			c = 1
+		}
+	}
+	switch {
+	case okA && !okB:
+		return -1
+	case !okA && okB:
+		return 1
+	case !okA && !okB:
+		return 0
+	}
+	if key.desc {
+		return -c
+	}
+	return c
+}
+
+// scoreNodes sets each node's score to the weighted sum of its fields, each
+// scaled to 0-1 across all nodes. A negative weight favours low values. A node
+// missing a field gets that field's worst value, and a field every node shares
+// scores the middle, 0.5.
+func scoreNodes(nodes []types.Proxy, weights map[string]float64, logger *log.Logger) {
+	for i := range nodes {
+		nodes[i].Score = 0
+	}
+	for field, weight := range weights {
+		if !isNumberField(field) || strings.ToLower(field) == "score" {
+			logger.Printf("Ignoring score weight for unknown numeric field %q", field)
+			continue
+		}
+		lo, hi, found := 0.0, 0.0, false
+		for _, node := range nodes {
+			if v, ok := nodeNumber(node, field); ok {
+				if !found || v < lo {
+					lo = v
+				}
+				if !found || v > hi {
+					hi = v
+				}
+				found = true
+			}
+		}
+		if !found {
+			continue
+		}
+		for i := range nodes {
+			scaled := 0.0 // Worst value for a positive weight
+			if weight < 0 {
+				scaled = 1 // Worst value for a negative weight
+			}
+			if v, ok := nodeNumber(nodes[i], field); ok {
+				scaled = 0.5 // Equal values neither help nor hurt
+				if hi > lo {
+					scaled = (v - lo) / (hi - lo)
+				}
+			}
+			nodes[i].Score += weight * scaled
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"io"
+	"log"
+	"math"
+	"reflect"
+	"testing"
+
+	"subs-check-custom/types"
+)
+
+func TestParseSortSpec(t *testing.T) {
+	tests := []struct {
+		spec    string
+		want    []sortKey
+		wantErr bool
+	}{
+		{spec: "-speed, latency, +Name", want: []sortKey{{field: "speed", desc: true}, {field: "latency"}, {field: "name", text: true}}},
+		{spec: " unlock.netflix ,, -http-p95", want: []sortKey{{field: "unlock.netflix"}, {field: "http-p95", desc: true}}},
+		{spec: "", want: nil},
+		{spec: "-speed, colour", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			got, err := parseSortSpec(tt.spec)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseSortSpec() = %v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseSortSpec() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompareField(t *testing.T) {
+	fast := types.Proxy{Name: "fast", Speed: 4096, Latency: 300}
+	slow := types.Proxy{Name: "slow", Speed: 512, Latency: 80}
+	untested := types.Proxy{Name: "untested"}
+	tests := []struct {
+		name string
+		a, b types.Proxy
+		key  sortKey
+		want int
+	}{
+		{"ascending", slow, fast, sortKey{field: "speed"}, -1},
+		{"descending", slow, fast, sortKey{field: "speed", desc: true}, 1},
+		{"equal", fast, fast, sortKey{field: "speed"}, 0},
+		{"missing last ascending", untested, slow, sortKey{field: "latency"}, 1},
+		{"missing last descending", slow, untested, sortKey{field: "speed", desc: true}, -1},
+		{"both missing", untested, untested, sortKey{field: "speed"}, 0},
+		{"text", fast, slow, sortKey{field: "name", text: true}, -1},
+		{"text descending", fast, slow, sortKey{field: "name", desc: true, text: true}, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := compareField(tt.a, tt.b, tt.key); got != tt.want {
+				t.Errorf("compareField() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSortNodesDefault(t *testing.T) {
+	// Without a speed test the default order falls back to latency
+	nodes := []types.Proxy{
+		{Name: "c", Latency: 300},
+		{Name: "untested"},
+		{Name: "a", Latency: 90},
+		{Name: "b", Latency: 90},
+	}
+	sortNodes(nodes, "", nil, log.New(io.Discard, "", 0))
+	var got []string
+	for _, node := range nodes {
+		got = append(got, node.Name)
+	}
+	if want := []string{"a", "b", "c", "untested"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("sorted to %v, want %v", got, want)
+	}
+}
+
+func TestScoreNodes(t *testing.T) {
+	logger := log.New(io.Discard, "", 0)
+	tests := []struct {
+		name    string
+		nodes   []types.Proxy
+		weights map[string]float64
+		want    []float64
+	}{
+		{
+			name:    "scaled",
+			nodes:   []types.Proxy{{Speed: 1000}, {Speed: 3000}, {Speed: 2000}},
+			weights: map[string]float64{"speed": 1},
+			want:    []float64{0, 1, 0.5},
+		},
+		{
+			name:    "negative weight favours low values",
+			nodes:   []types.Proxy{{Latency: 100}, {Latency: 300}},
+			weights: map[string]float64{"latency": -1},
+			want:    []float64{-0, -1},
+		},
+		{
+			name:    "missing gets the worst value",
+			nodes:   []types.Proxy{{Speed: 1000, Latency: 100}, {Speed: 2000}, {}},
+			weights: map[string]float64{"speed": 1, "latency": -0.5},
+			want:    []float64{0 - 0.25, 1 - 0.5, 0 - 0.5},
+		},
+		{
+			name:    "equal values are neutral",
+			nodes:   []types.Proxy{{Latency: 200}, {Latency: 200}, {}},
+			weights: map[string]float64{"latency": -1},
+			want:    []float64{-0.5, -0.5, -1},
+		},
+		{
+			name:    "unknown field ignored",
+			nodes:   []types.Proxy{{Speed: 1000}, {Speed: 2000}},
+			weights: map[string]float64{"colour": 1, "score": 1, "speed": 2},
+			want:    []float64{0, 2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			scoreNodes(tt.nodes, tt.weights, logger)
+			for i, node := range tt.nodes {
+				if math.Abs(node.Score-tt.want[i]) > 1e-9 {
+					t.Errorf("node %d score = %.3f, want %.3f", i, node.Score, tt.want[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,236 @@
+// Package singbox turns parsed proxies into sing-box outbounds
+package singbox
+
+import (
+	"fmt"
+	"strings"
+
+	"subs-check-custom/types"
+)
+
+// Outbound is one entry of a sing-box outbounds array. Node outbounds fill the
+// server fields and group outbounds (selector, urltest) fill Outbounds.
+type Outbound struct {
+	Type       string `json:"type"`
+	Tag        string `json:"tag"`
+	Server     string `json:"server,omitempty"`
+	ServerPort int    `json:"server_port,omitempty"`
+
+	Method   string `json:"method,omitempty"`   // shadowsocks
+	Password string `json:"password,omitempty"` // shadowsocks, trojan, hysteria2
+	UUID     string `json:"uuid,omitempty"`     // vmess, vless
+	Security string `json:"security,omitempty"` // vmess
+	AlterID  int    `json:"alter_id,omitempty"` // vmess
+	Flow     string `json:"flow,omitempty"`     // vless
+	Obfs     *Obfs  `json:"obfs,omitempty"`     // hysteria2
+
+	TLS       *TLS       `json:"tls,omitempty"`
+	Transport *Transport `json:"transport,omitempty"`
+	Multiplex *Multiplex `json:"multiplex,omitempty"`
+
+	Outbounds []string `json:"outbounds,omitempty"` // selector, urltest
+	Default   string   `json:"default,omitempty"`   // selector
+	URL       string   `json:"url,omitempty"`       // urltest
+	Interval  string   `json:"interval,omitempty"`  // urltest
+	Tolerance int      `json:"tolerance,omitempty"` // urltest, ms
+}
+
+// TLS is the outbound TLS block, with REALITY when Reality is set
+type TLS struct {
+	Enabled    bool     `json:"enabled"`
+	ServerName string   `json:"server_name,omitempty"`
+	Insecure   bool     `json:"insecure,omitempty"`
+	ALPN       []string `json:"alpn,omitempty"`
+	UTLS       *UTLS    `json:"utls,omitempty"`
+	Reality    *Reality `json:"reality,omitempty"`
+}
+
+// UTLS selects a client fingerprint
+type UTLS struct {
+	Enabled     bool   `json:"enabled"`
+	Fingerprint string `json:"fingerprint"`
+}
+
+// Reality holds the REALITY server's public key and short ID
+type Reality struct {
+	Enabled   bool   `json:"enabled"`
+	PublicKey string `json:"public_key"`
+	ShortID   string `json:"short_id,omitempty"`
+}
+
+// Transport is the V2Ray transport of vmess, vless and trojan outbounds
+type Transport struct {
+	Type        string            `json:"type"`
+	Path        string            `json:"path,omitempty"`         // ws, httpupgrade
+	Headers     map[string]string `json:"headers,omitempty"`      // ws
+	Host        string            `json:"host,omitempty"`         // httpupgrade
+	ServiceName string            `json:"service_name,omitempty"` // grpc
+}
+
+// Obfs is the hysteria2 obfuscation
+type Obfs struct {
+	Type     string `json:"type"`
+	Password string `json:"password"`
+}
+
+// Multiplex enables connection multiplexing with smux, yamux or h2mux
+type Multiplex struct {
+	Enabled  bool   `json:"enabled"`
+	Protocol string `json:"protocol"`
+}
+
+// Convert returns the sing-box outbound for node, or an error if sing-box cannot
+// carry it. multiplex names the multiplex protocol, empty to leave it off.
+func Convert(node types.Proxy, multiplex string) (Outbound, error) {
+	out := Outbound{
+		Tag:        node.Name,
+		Server:     node.Server,
+		ServerPort: node.Port,
+	}
+
+	switch node.Type {
+	case "ss":
+		out.Type = "shadowsocks"
+		out.Method = node.Cipher
+		out.Password = node.Password
+	case "vmess":
+		out.Type = "vmess"
+		out.UUID = node.UUID
+		out.AlterID = node.AlterID
+		out.Security = node.Cipher
+		if out.Security == "" {
+			out.Security = "auto"
+		}
+	case "vless":
+		out.Type = "vless"
+		out.UUID = node.UUID
+		out.Flow = node.Flow
+	case "trojan":
+		out.Type = "trojan"
+		out.Password = node.Password
+	case "hysteria2":
+		out.Type = "hysteria2"
+		out.Password = node.Password
+		if node.Obfs != "" {
+			out.Obfs = &Obfs{Type: node.Obfs, Password: node.ObfsPassword}
+		}
+		out.TLS = tlsBlock(node)
+		return out, nil // QUIC based, so no V2Ray transport or multiplex
+	default:
+		// sing-box dropped ShadowsocksR
+		return Outbound{}, fmt.Errorf("%s nodes are not supported by sing-box", node.Type)
+	}
+
+	if node.TLS || node.Type == "trojan" {
+		out.TLS = tlsBlock(node)
+	}
+	if node.Type != "ss" {
+		transport, err := transportBlock(node)
+		if err != nil {
+			return Outbound{}, err
+		}
+		out.Transport = transport
+	}
+	// Multiplex does not work with XTLS flows
+	if multiplex != "" && out.Flow == "" {
+		out.Multiplex = &Multiplex{Enabled: true, Protocol: multiplex}
+	}
+	return out, nil
+}
+
+// tlsBlock maps the node's TLS or REALITY settings
+func tlsBlock(node types.Proxy) *TLS {
+	tls := &TLS{
+		Enabled:    true,
+		ServerName: node.SNI,
+		Insecure:   node.SkipCertVerify,
+		ALPN:       node.ALPN,
+	}
+	if tls.ServerName == "" {
+		tls.ServerName = node.WSOpts["host"]
+	}
+	fingerprint := node.ClientFingerprint
+	if key := node.RealityOpts["public-key"]; key != "" {
+		tls.Reality = &Reality{Enabled: true, PublicKey: key, ShortID: node.RealityOpts["short-id"]}
+		if fingerprint == "" {
+			fingerprint = "chrome" // REALITY requires uTLS
+		}
+	}
+	if fingerprint != "" {
+		tls.UTLS = &UTLS{Enabled: true, Fingerprint: fingerprint}
+	}
+	return tls
+}
+
+// transportBlock maps the node's network, or returns nil for plain TCP
+func transportBlock(node types.Proxy) (*Transport, error) {
+	path := node.WSOpts["path"]
+	if path == "" {
+		path = node.Path
+	}
+	host := node.WSOpts["host"]
+
+	switch n := strings.ToLower(node.Network); n {
+	case "", "tcp", "raw":
+		return nil, nil
+	case "ws", "websocket":
+		t := &Transport{Type: "ws", Path: path}
+		if host != "" {
+			t.Headers = map[string]string{"Host": host}
+		}
+		return t, nil
+	case "httpupgrade":
+		return &Transport{Type: "httpupgrade", Path: path, Host: host}, nil
+	case "grpc":
+		return &Transport{Type: "grpc", ServiceName: node.GrpcOpts["grpc-service-name"]}, nil
+	default:
+		return nil, fmt.Errorf("%s transport is not supported by sing-box", n)
+	}
+}
+
+// ConvertAll converts every node sing-box can carry and reports the rest through skipped
+func ConvertAll(nodes []types.Proxy, multiplex string, skipped func(node types.Proxy, err error)) []Outbound {
+	outbounds := make([]Outbound, 0, len(nodes))
+	for _, node := range nodes {
+		out, err := Convert(node, multiplex)
+		if err != nil {
+			if skipped != nil {
+				skipped(node, err)
+			}
+			continue
+		}
+		outbounds = append(outbounds, out)
+	}
+	return outbounds
+}
+
+// Config wraps outbounds in a complete sing-box config: a mixed inbound on
+// 127.0.0.1:7890, a "proxy" selector defaulting to an "auto" urltest over every
+// node, and a direct outbound
+func Config(outbounds []Outbound, testURL string) map[string]any {
+	tags := make([]string, len(outbounds))
+	for i, out := range outbounds {
+		tags[i] = out.Tag
+	}
+	all := []Outbound{
+		{Type: "selector", Tag: "proxy", Outbounds: append([]string{"auto"}, tags...), Default: "auto"},
+		{Type: "urltest", Tag: "auto", Outbounds: tags, URL: testURL, Interval: "5m", Tolerance: 50},
+	}
+	all = append(all, outbounds...)
+	all = append(all, Outbound{Type: "direct", Tag: "direct"})
+
+	return map[string]any{
+		"log": map[string]any{"level": "info"},
+		"inbounds": []map[string]any{{
+			"type":        "mixed",
+			"tag":         "mixed-in",
+			"listen":      "127.0.0.1",
+			"listen_port": 7890,
+		}},
+		"outbounds": all,
+		"route": map[string]any{
+			"final":                 "proxy",
+			"auto_detect_interface": true,
+		},
+	}
+}
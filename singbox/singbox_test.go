@@ -0,0 +1,145 @@
+package singbox
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"subs-check-custom/types"
+)
+
+const uuid = "b831381d-6324-4d53-ad4f-8cda48b30811"
+
+func TestConvert(t *testing.T) {
+	tests := []struct {
+		name      string
+		node      types.Proxy
+		multiplex string
+		want      Outbound
+	}{
+		{
+			name: "shadowsocks",
+			node: types.Proxy{Type: "ss", Server: "ss.example.com", Port: 8388, Cipher: "aes-256-gcm", Password: "secret"},
+			want: Outbound{Type: "shadowsocks", Server: "ss.example.com", ServerPort: 8388, Method: "aes-256-gcm", Password: "secret"},
+		},
+		{
+			name:      "shadowsocks multiplex",
+			node:      types.Proxy{Type: "ss", Server: "ss.example.com", Port: 8388, Cipher: "aes-256-gcm", Password: "secret"},
+			multiplex: "h2mux",
+			want: Outbound{Type: "shadowsocks", Server: "ss.example.com", ServerPort: 8388, Method: "aes-256-gcm", Password: "secret",
+				Multiplex: &Multiplex{Enabled: true, Protocol: "h2mux"}},
+		},
+		{
+			name: "vmess ws tls",
+			node: types.Proxy{Type: "vmess", Server: "vm.example.com", Port: 443, UUID: uuid, AlterID: 0,
+				Network: "ws", WSOpts: map[string]string{"path": "/ws", "host": "cdn.example.com"}, TLS: true, SkipCertVerify: true},
+			multiplex: "smux",
+			want: Outbound{Type: "vmess", Server: "vm.example.com", ServerPort: 443, UUID: uuid, Security: "auto",
+				TLS:       &TLS{Enabled: true, ServerName: "cdn.example.com", Insecure: true},
+				Transport: &Transport{Type: "ws", Path: "/ws", Headers: map[string]string{"Host": "cdn.example.com"}},
+				Multiplex: &Multiplex{Enabled: true, Protocol: "smux"}},
+		},
+		{
+			name: "vless reality vision",
+			node: types.Proxy{Type: "vless", Server: "reality.example.com", Port: 443, UUID: uuid, Flow: "xtls-rprx-vision",
+				TLS: true, SNI: "www.microsoft.com",
+				RealityOpts: map[string]string{"public-key": "Z84J2IelR9ch3k8VtlVhhs5ycBUlXA7wHBWcBrjqnAw", "short-id": "6ba85179e30d4fc2"}},
+			multiplex: "smux", // Left off for XTLS flows
+			want: Outbound{Type: "vless", Server: "reality.example.com", ServerPort: 443, UUID: uuid, Flow: "xtls-rprx-vision",
+				TLS: &TLS{Enabled: true, ServerName: "www.microsoft.com",
+					UTLS:    &UTLS{Enabled: true, Fingerprint: "chrome"},
+					Reality: &Reality{Enabled: true, PublicKey: "Z84J2IelR9ch3k8VtlVhhs5ycBUlXA7wHBWcBrjqnAw", ShortID: "6ba85179e30d4fc2"}}},
+		},
+		{
+			name: "vless grpc",
+			node: types.Proxy{Type: "vless", Server: "grpc.example.com", Port: 443, UUID: uuid,
+				Network: "grpc", GrpcOpts: map[string]string{"grpc-service-name": "tunnel"},
+				TLS: true, SNI: "grpc.example.com", ALPN: []string{"h2"}, ClientFingerprint: "firefox"},
+			want: Outbound{Type: "vless", Server: "grpc.example.com", ServerPort: 443, UUID: uuid,
+				TLS:       &TLS{Enabled: true, ServerName: "grpc.example.com", ALPN: []string{"h2"}, UTLS: &UTLS{Enabled: true, Fingerprint: "firefox"}},
+				Transport: &Transport{Type: "grpc", ServiceName: "tunnel"}},
+		},
+		{
+			name: "vless httpupgrade",
+			node: types.Proxy{Type: "vless", Server: "hu.example.com", Port: 80, UUID: uuid,
+				Network: "httpupgrade", WSOpts: map[string]string{"path": "/up", "host": "hu.example.com"}},
+			want: Outbound{Type: "vless", Server: "hu.example.com", ServerPort: 80, UUID: uuid,
+				Transport: &Transport{Type: "httpupgrade", Path: "/up", Host: "hu.example.com"}},
+		},
+		{
+			name: "trojan always tls",
+			node: types.Proxy{Type: "trojan", Server: "trojan.example.com", Port: 443, Password: "secret", SNI: "trojan.example.com", Network: "tcp"},
+			want: Outbound{Type: "trojan", Server: "trojan.example.com", ServerPort: 443, Password: "secret",
+				TLS: &TLS{Enabled: true, ServerName: "trojan.example.com"}},
+		},
+		{
+			name: "hysteria2 obfs",
+			node: types.Proxy{Type: "hysteria2", Server: "hy2.example.com", Port: 443, Password: "secret",
+				Obfs: "salamander", ObfsPassword: "obfs", SNI: "hy2.example.com", Network: "udp"},
+			multiplex: "smux", // QUIC based, never multiplexed
+			want: Outbound{Type: "hysteria2", Server: "hy2.example.com", ServerPort: 443, Password: "secret",
+				Obfs: &Obfs{Type: "salamander", Password: "obfs"},
+				TLS:  &TLS{Enabled: true, ServerName: "hy2.example.com"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.node.Name = tt.name
+			tt.want.Tag = tt.name
+			got, err := Convert(tt.node, tt.multiplex)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				gotJSON, _ := json.Marshal(got)
+				wantJSON, _ := json.Marshal(tt.want)
+				t.Errorf("Convert() =\n%s\nwant\n%s", gotJSON, wantJSON)
+			}
+		})
+	}
+}
+
+func TestConvertUnsupported(t *testing.T) {
+	for _, node := range []types.Proxy{
+		{Name: "ssr", Type: "ssr", Server: "ssr.example.com", Port: 8989},
+		{Name: "xhttp", Type: "vless", Server: "x.example.com", Port: 443, UUID: uuid, Network: "splithttp"},
+	} {
+		if _, err := Convert(node, ""); err == nil {
+			t.Errorf("Convert(%s) succeeded, want an error", node.Name)
+		}
+	}
+	var skipped []string
+	outbounds := ConvertAll([]types.Proxy{{Name: "ssr", Type: "ssr"}, {Name: "ss", Type: "ss"}}, "", func(node types.Proxy, err error) {
+		skipped = append(skipped, node.Name)
+	})
+	if len(outbounds) != 1 || outbounds[0].Tag != "ss" || !reflect.DeepEqual(skipped, []string{"ssr"}) {
+		t.Errorf("ConvertAll() = %v, skipped %v", outbounds, skipped)
+	}
+}
+
+func TestConfig(t *testing.T) {
+	outbounds := []Outbound{{Type: "shadowsocks", Tag: "a"}, {Type: "trojan", Tag: "b"}}
+	config := Config(outbounds, "https://www.gstatic.com/generate_204")
+
+	all := config["outbounds"].([]Outbound)
+	var tags []string
+	for _, out := range all {
+		tags = append(tags, out.Tag)
+	}
+	if want := []string{"proxy", "auto", "a", "b", "direct"}; !reflect.DeepEqual(tags, want) {
+		t.Errorf("outbound tags = %v, want %v", tags, want)
+	}
+	selector, urltest := all[0], all[1]
+	if selector.Type != "selector" || selector.Default != "auto" || !reflect.DeepEqual(selector.Outbounds, []string{"auto", "a", "b"}) {
+		t.Errorf("selector = %+v", selector)
+	}
+	if urltest.Type != "urltest" || urltest.URL != "https://www.gstatic.com/generate_204" || !reflect.DeepEqual(urltest.Outbounds, []string{"a", "b"}) {
+		t.Errorf("urltest = %+v", urltest)
+	}
+	if route := config["route"].(map[string]any); route["final"] != "proxy" {
+		t.Errorf("route = %v, want final proxy", route)
+	}
+	if _, err := json.Marshal(config); err != nil {
+		t.Errorf("config does not marshal: %v", err)
+	}
+}
@@ -1,34 +1,20 @@
 package main
 
 import (
-    "context"
-    "fmt"
-    "io"
-    "log"
-    "net"
-    "net/http"
-    "os"
-    "sync"
-    "time"
-
-    "golang.org/x/net/proxy"
-    "google.golang.org/grpc"
-    "google.golang.org/grpc/credentials/insecure"
-
-    "subs-check-custom/types"
-
-    "github.com/xtls/xray-core/app/dispatcher"
-    "github.com/xtls/xray-core/app/proxyman/command"
-    xnet "github.com/xtls/xray-core/common/net"
-    "github.com/xtls/xray-core/common/protocol"
-    "github.com/xtls/xray-core/common/serial"
-    "github.com/xtls/xray-core/core"
-    "github.com/xtls/xray-core/infra/conf/configpb" // Added for protocol buffer type
-    "github.com/xtls/xray-core/proxy/vless"
-    "github.com/xtls/xray-core/proxy/vless/outbound"
-    "github.com/xtls/xray-core/transport/internet"
-    "github.com/xtls/xray-core/transport/internet/tls"
-    "github.com/xtls/xray-core/transport/internet/websocket"
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/net/proxy"
+
+	"subs-check-custom/outbound"
+	"subs-check-custom/types"
 )
 
 // dialContextAdapter adapts a proxy.Dialer to a DialContext function
@@ -59,320 +45,230 @@ func (d *dialContextAdapter) DialContext(ctx context.Context, network, addr stri
 	}
 }
 
-// tcpTest tests HTTP connectivity to cfg.TCPTestURL through Xray's SOCKS5 proxy
-func tcpTest(cfg types.Config, nodes []types.Proxy, testLogger *log.Logger) []types.Proxy {
-	var wg sync.WaitGroup
-	testedNodes := make([]types.Proxy, 0, len(nodes)) // Only successful nodes
-	mutex := &sync.Mutex{}
-	successCount := 0
-	failedCount := 0
-	const maxRetries = 2 // Number of retries for each node
-
-	// Connect to Xray's API using the configured address
-	conn, err := grpc.Dial(cfg.ApiAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
-	if err != nil {
-		testLogger.Printf("Failed to connect to Xray API at %s: %v", cfg.ApiAddr, err)
-		return nodes
-	}
-	defer conn.Close()
-
-	client := command.NewHandlerServiceClient(conn)
-
-	for i, node := range nodes {
-		wg.Add(1)
-		testLogger.Printf("Node %d: Switching to %s:%d (%s)", i, node.Server, node.Port, node.Name)
-
-		// Switch to the current node using the API
-		err := switchNode(client, node, i)
+// tcpTest tests HTTP connectivity to cfg.TCPTestURL through each node and keeps the nodes that pass
+func tcpTest(cfg types.Config, nodes []types.Proxy, sched *testScheduler, testLogger *log.Logger, dropFailed bool) []types.Proxy {
+	results, counts := sched.run("TCP test", nodes, func(nodeIndex int, n *types.Proxy, proxyAddr string) error {
+		testLogger.Printf("Node %d: Running TCP test to %s (Name: %s)", nodeIndex, cfg.TCPTestURL, n.Name)
+		client, err := newProxyClient(proxyAddr, time.Duration(cfg.Timeout)*time.Millisecond)
 		if err != nil {
-			testLogger.Printf("Node %d: Failed to switch to %s:%d (%s): %v", i, node.Server, node.Port, node.Name, err)
-			failedCount++
-			wg.Done()
-			continue
+			testLogger.Printf("Node %d: Fail - Failed to create SOCKS5 dialer for %s (%v)", nodeIndex, proxyAddr, err)
+			return err
 		}
 
-		testLogger.Printf("Node %d: Running TCP test to %s (Name: %s)", i, cfg.TCPTestURL, node.Name)
-		go func(n types.Proxy, nodeIndex int) {
-			defer wg.Done()
-
-			var lastErr error
-			for attempt := 0; attempt <= maxRetries; attempt++ {
-				ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.Timeout*2)*time.Millisecond) // Double the timeout
-				defer cancel()
-
-				// Use Xray's SOCKS5 proxy
-				dialer, err := proxy.SOCKS5("tcp", cfg.ProxyAddr, nil, proxy.Direct)
-				if err != nil {
-					testLogger.Printf("Node %d: Fail - Failed to create SOCKS5 dialer for %s (%v)", nodeIndex, cfg.ProxyAddr, err) // Fixed syntax here
-					failedCount++
-					return
-				}
-				adapter := &dialContextAdapter{dialer: dialer}
-				transport := &http.Transport{
-					DialContext: adapter.DialContext,
-				}
-				client := &http.Client{
-					Transport: transport,
-					Timeout:   time.Duration(cfg.Timeout*2) * time.Millisecond, // Double the timeout
-				}
-
-				req, err := http.NewRequestWithContext(ctx, "GET", cfg.TCPTestURL, nil)
-				if err != nil {
-					testLogger.Printf("Node %d: Fail - Failed to create request (%v)", nodeIndex, err)
-					failedCount++
-					return
-				}
-
-				start := time.Now()
-				resp, err := client.Do(req)
-				if err != nil {
-					lastErr = err
-					testLogger.Printf("Node %d: Attempt %d failed - TCP test failed for %s (%v)", nodeIndex, attempt+1, n.Name, err)
-					if attempt < maxRetries {
-						time.Sleep(time.Second) // Wait 1 second before retrying
-						continue
-					}
-					testLogger.Printf("Node %d: Fail - TCP test failed for %s after %d attempts (%v)", nodeIndex, n.Name, maxRetries+1, lastErr)
-					failedCount++
-					return
-				}
-				defer resp.Body.Close()
+		var duration time.Duration
+		err = sched.retry.do(func(attempt int) error {
+			if duration, err = timedGet(client, cfg.TCPTestURL); err != nil {
+				testLogger.Printf("Node %d: Attempt %d failed - TCP test failed for %s (%s: %v)", nodeIndex, attempt, n.Name, classifyFailure(err), err)
+			}
+			return err
+		})
+		if err != nil {
+			testLogger.Printf("Node %d: Fail - TCP test failed for %s (%v)", nodeIndex, n.Name, err)
+			return err
+		}
 
-				// Check if response is successful (status 200)
-				if resp.StatusCode != http.StatusOK {
-					lastErr = fmt.Errorf("non-200 status: %d", resp.StatusCode)
-					testLogger.Printf("Node %d: Attempt %d failed - TCP test received non-200 status (%d) for %s", nodeIndex, attempt+1, resp.StatusCode, n.Name)
-					if attempt < maxRetries {
-						time.Sleep(time.Second)
-						continue
-					}
-					testLogger.Printf("Node %d: Fail - TCP test failed for %s after %d attempts (non-200 status: %d)", nodeIndex, n.Name, maxRetries+1, resp.StatusCode)
-					failedCount++
-					return
-				}
+		latency := duration.Milliseconds()
+		if latency > int64(cfg.TCPTestMaxSpeed) {
+			testLogger.Printf("Node %d: Fail - TCP test exceeded max speed (%d ms > %d ms) for %s", nodeIndex, latency, cfg.TCPTestMaxSpeed, n.Name)
+			return fmt.Errorf("%w: %d ms > %d ms", errThreshold, latency, cfg.TCPTestMaxSpeed)
+		}
+		testLogger.Printf("Node %d: Success - TCP test passed (%d ms) for %s", nodeIndex, latency, n.Name)
+		n.Latency = latency // Store latency in the node struct
+		return nil
+	})
 
-				duration := time.Since(start).Milliseconds()
-				if duration > int64(cfg.TCPTestMaxSpeed) {
-					testLogger.Printf("Node %d: Fail - TCP test exceeded max speed (%d ms > %d ms) for %s", nodeIndex, duration, cfg.TCPTestMaxSpeed, n.Name)
-					failedCount++
-					return
-				}
+	testLogger.Printf("TCP Test - Total nodes: %d, Successful: %d, Failed: %d, Skipped: %d, Failures: %s", counts.Total, counts.Passed, counts.Failed, counts.Skipped, formatFailures(counts.Failures))
+	return keepResults(nodes, results, dropFailed)
+}
 
-				testLogger.Printf("Node %d: Success - TCP test passed (%d ms) for %s", nodeIndex, duration, n.Name)
-				mutex.Lock()
-				n.Latency = duration // Store latency in the node struct
-				testedNodes = append(testedNodes, n)
-				successCount++
-				mutex.Unlock()
-				return // Success, no need to retry
-			}
-		}(node, i)
+// timedGet fetches url and returns the time until the response completed with status 200
+func timedGet(client *http.Client, url string) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), client.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return 0, err
 	}
-	wg.Wait()
 
-	testLogger.Printf("TCP Test - Total nodes: %d, Successful: %d, Failed: %d", len(nodes), successCount, failedCount)
-	return testedNodes
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, statusError{resp.StatusCode}
+	}
+	return time.Since(start), nil
 }
 
-func switchNode(client command.HandlerServiceClient, node types.Proxy, nodeIndex int) error {
-    ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-    defer cancel()
-
-    // Create a VLESS user
-    user := &protocol.User{
-        Account: serial.ToTypedMessage(&vless.Account{
-            Id: node.UUID,
-        }),
-    }
+// xrayTestable reports whether the Xray-based tester can build an outbound for the node
+func xrayTestable(node types.Proxy) bool {
+	return outbound.Supported(node)
+}
 
-    // Construct the VLESS outbound configuration using configpb
-    outbound := &configpb.OutboundHandlerConfig{
-        Tag: fmt.Sprintf("proxy_%d", nodeIndex),
-        ProxySettings: serial.ToTypedMessage(&outbound.Config{
-            Vnext: []*protocol.ServerEndpoint{
-                {
-                    Address: xnet.NewIPOrDomain(xnet.ParseAddress(node.Server)),
-                    Port:    uint32(node.Port),
-                    User:    []*protocol.User{user},
-                },
-            },
-        }),
-        SenderSettings: serial.ToTypedMessage(&dispatcher.Config{}),
-        StreamSettings: serial.ToTypedMessage(&internet.StreamConfig{
-            ProtocolName: "websocket",
-            TransportSettings: []*internet.TransportConfig{
-                {
-                    ProtocolName: "websocket",
-                    Settings: serial.ToTypedMessage(&websocket.Config{
-                        Path: node.Path,
-                        Header: map[string]string{
-                            "Host": node.Server,
-                        },
-                    }),
-                },
-            },
-            SecurityType: "tls",
-            SecuritySettings: []*serial.TypedMessage{
-                serial.ToTypedMessage(&tls.Config{
-                    ServerName: node.SNI,
-                }),
-            },
-        }),
-    }
+// speedTest runs the time-boxed download test on nodes and records the measured speed
+func speedTest(cfg types.Config, nodes []types.Proxy, sched *testScheduler, testLogger *log.Logger, dropFailed bool) []types.Proxy {
+	testedNodes := make([]types.Proxy, len(nodes))
+	copy(testedNodes, nodes)
 
-    // Remove existing outbound if it exists
-    _, err := client.RemoveOutbound(ctx, &command.RemoveOutboundRequest{
-        Tag: fmt.Sprintf("proxy_%d", nodeIndex),
-    })
-    // Ignore error if outbound doesn't exist
+	duration := time.Duration(cfg.SpeedTestDuration) * time.Millisecond
+	if duration <= 0 {
+		duration = time.Duration(cfg.Timeout) * time.Millisecond
+	}
+	warmup := time.Duration(cfg.SpeedTestWarmup) * time.Millisecond
+	streams := cfg.SpeedTestStreams
+	if streams < 1 {
+		streams = 1
+	}
 
-    // Add the new outbound
-    _, err = client.AddOutbound(ctx, &command.AddOutboundRequest{Outbound: outbound})
-    if err != nil {
-        return fmt.Errorf("failed to add outbound: %v", err)
-    }
+	results, counts := sched.run("Download speed test", testedNodes, func(nodeIndex int, n *types.Proxy, proxyAddr string) error {
+		testLogger.Printf("Node %d: Running speed test (%d streams, %s)", nodeIndex, streams, duration)
+		// The download is bounded by its own deadline rather than the client timeout
+		client, err := newProxyClient(proxyAddr, 0)
+		if err != nil {
+			testLogger.Printf("Node %d: Fail - Failed to create SOCKS5 dialer (%v)", nodeIndex, err)
+			return err
+		}
 
-    // Update routing to use the new outbound
-    _, err = client.AlterOutbound(ctx, &command.AlterOutboundRequest{
-        Tag: fmt.Sprintf("proxy_%d", nodeIndex),
-    })
-    if err != nil {
-        return fmt.Errorf("failed to alter outbound: %v", err)
-    }
+		var avg, peak float64
+		err = sched.retry.do(func(attempt int) error {
+			if avg, peak, err = measureDownload(client, cfg.SpeedTestURL, streams, duration, warmup); err != nil {
+				testLogger.Printf("Node %d: Attempt %d failed - Speed test failed (%s: %v)", nodeIndex, attempt, classifyFailure(err), err)
+			}
+			return err
+		})
+		if err != nil {
+			testLogger.Printf("Node %d: Fail - Speed test failed (%v)", nodeIndex, err)
+			return err
+		}
+		n.Speed = avg
+		n.PeakSpeed = peak
+		if avg < float64(cfg.MinSpeed) {
+			testLogger.Printf("Node %d: Fail - Speed below minimum (%.1f KB/s, peak %.1f KB/s)", nodeIndex, avg, peak)
+			return fmt.Errorf("%w: %.1f KB/s < %d KB/s", errThreshold, avg, cfg.MinSpeed)
+		}
+		testLogger.Printf("Node %d: Success - Speed test passed (%.1f KB/s, peak %.1f KB/s)", nodeIndex, avg, peak)
+		return nil
+	})
 
-    return nil
+	testLogger.Printf("Speed Test - Total nodes: %d, Successful: %d, Failed: %d, Skipped: %d, Failures: %s", counts.Total, counts.Passed, counts.Failed, counts.Skipped, formatFailures(counts.Failures))
+	return keepResults(testedNodes, results, dropFailed)
 }
 
-// speedTest performs the download speed test on nodes
-func speedTest(cfg types.Config, nodes []types.Proxy, testLogger *log.Logger) []types.Proxy {
-	totalNodes := len(nodes)
-	fmt.Printf("Total nodes to speed test: %d\n", totalNodes)
+// speedSampleInterval is how often the download test samples throughput for the peak speed
+const speedSampleInterval = 250 * time.Millisecond
 
-	var wg sync.WaitGroup
-	testedNodes := make([]types.Proxy, len(nodes))
-	copy(testedNodes, nodes)
+// measureDownload downloads url over streams parallel requests until duration
+// has passed, restarting a stream whenever its body ends early. It returns the
+// average and peak speed in KB/s, ignoring bytes received during warmup.
+func measureDownload(client *http.Client, url string, streams int, duration, warmup time.Duration) (avg, peak float64, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), duration)
+	defer cancel()
 
-	mutex := &sync.Mutex{}
-	successCount := 0
-	failedCount := 0
-	completedCount := 0
-	var countMutex sync.Mutex
+	var received atomic.Int64
+	errs := make(chan error, streams)
+	var wg sync.WaitGroup
+	for i := 0; i < streams; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- downloadStream(ctx, client, url, &received)
+		}()
+	}
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
 
-	for i := 0; i < len(nodes); i += cfg.Concurrent {
-		end := i + cfg.Concurrent
-		if end > len(nodes) {
-			end = len(nodes)
+	start := time.Now()
+	ticker := time.NewTicker(speedSampleInterval)
+	defer ticker.Stop()
+	var warmBytes int64
+	var warmAt time.Time
+	if warmup <= 0 {
+		warmAt = start // Nothing to skip, so count from the first byte
+	}
+	lastBytes, lastAt := int64(0), start
+	sample := func(now time.Time) {
+		total := received.Load()
+		if warmAt.IsZero() && now.Sub(start) >= warmup {
+			warmBytes, warmAt = total, now
+		} else if !warmAt.IsZero() && now.Sub(lastAt) > 0 {
+			if rate := float64(total-lastBytes) / now.Sub(lastAt).Seconds() / 1024; rate > peak {
+				peak = rate
+			}
 		}
-		batch := nodes[i:end]
-		wg.Add(len(batch))
-		for idx, node := range batch {
-			nodeIndex := i + idx
-			testLogger.Printf("Node %d: Running speed test", nodeIndex)
-			go func(n types.Proxy, nodeIndex int) {
-				defer wg.Done()
-
-				ctx, cancel := context.WithTimeout(context.Background(), time.Duration(cfg.Timeout)*time.Millisecond)
-				defer cancel()
-
-				dialer, err := proxy.SOCKS5("tcp", cfg.ProxyAddr, nil, proxy.Direct)
-				if err != nil {
-					countMutex.Lock()
-					failedCount++
-					completedCount++
-					testLogger.Printf("Node %d: Fail - Failed to create SOCKS5 dialer (%v)", nodeIndex, err)
-					fmt.Printf("\rNodes speed tested: %d/%d", completedCount, totalNodes)
-					countMutex.Unlock()
-					return
-				}
-				adapter := &dialContextAdapter{dialer: dialer}
-				transport := &http.Transport{
-					DialContext: adapter.DialContext,
-				}
-				client := &http.Client{
-					Transport: transport,
-					Timeout:   time.Duration(cfg.Timeout) * time.Millisecond,
-				}
-
-				req, err := http.NewRequestWithContext(ctx, "GET", cfg.SpeedTestURL, nil)
-				if err != nil {
-					countMutex.Lock()
-					failedCount++
-					completedCount++
-					testLogger.Printf("Node %d: Fail - Failed to create request (%v)", nodeIndex, err)
-					fmt.Printf("\rNodes speed tested: %d/%d", completedCount, totalNodes)
-					countMutex.Unlock()
-					return
-				}
-				start := time.Now()
-				resp, err := client.Do(req)
-				if err != nil {
-					countMutex.Lock()
-					failedCount++
-					completedCount++
-					testLogger.Printf("Node %d: Fail - Speed test failed (%v)", nodeIndex, err)
-					fmt.Printf("\rNodes speed tested: %d/%d", completedCount, totalNodes)
-					countMutex.Unlock()
-					return
-				}
-				defer resp.Body.Close()
+		lastBytes, lastAt = total, now
+	}
 
-				var totalBytes int64
-				buf := make([]byte, 1024)
-				for {
-					select {
-					case <-ctx.Done():
-						countMutex.Lock()
-						failedCount++
-						completedCount++
-						testLogger.Printf("Node %d: Fail - Speed test timeout", nodeIndex)
-						fmt.Printf("\rNodes speed tested: %d/%d", completedCount, totalNodes)
-						countMutex.Unlock()
-						return
-					default:
-						bytesRead, err := resp.Body.Read(buf)
-						totalBytes += int64(bytesRead)
-						if err != nil {
-							countMutex.Lock()
-							completedCount++
-							if err == io.EOF {
-								duration := time.Since(start).Seconds()
-								if duration == 0 {
-									failedCount++
-									testLogger.Printf("Node %d: Fail - Speed test duration zero", nodeIndex)
-								} else {
-									speed := float64(totalBytes) / duration / 1024 // KB/s
-									mutex.Lock()
-									testedNodes[nodeIndex].Speed = speed
-									mutex.Unlock()
-									if speed >= float64(cfg.MinSpeed) {
-										successCount++
-										testLogger.Printf("Node %d: Success - Speed test passed (%.1f KB/s)", nodeIndex, speed)
-									} else {
-										failedCount++
-										testLogger.Printf("Node %d: Fail - Speed below minimum (%.1f KB/s)", nodeIndex, speed)
-									}
-								}
-							} else {
-								failedCount++
-								testLogger.Printf("Node %d: Fail - Speed test read error (%v)", nodeIndex, err)
-							}
-							fmt.Printf("\rNodes speed tested: %d/%d", completedCount, totalNodes)
-							countMutex.Unlock()
-							return
-						}
-					}
-				}
-			}(node, nodeIndex)
+loop:
+	for {
+		select {
+		case now := <-ticker.C:
+			sample(now)
+		case <-done:
+			break loop
 		}
-		wg.Wait()
 	}
+	end := time.Now()
+	sample(end)
+
+	total := received.Load()
+	if total == 0 {
+		close(errs)
+		for streamErr := range errs {
+			if streamErr != nil {
+				return 0, 0, streamErr
+			}
+		}
+		return 0, 0, fmt.Errorf("no data received before the deadline: %w", context.DeadlineExceeded)
+	}
+	if warmAt.IsZero() || !end.After(warmAt) {
+		// The test ended inside the warm-up window, so measure everything
+		warmBytes, warmAt = 0, start
+	}
+	avg = float64(total-warmBytes) / end.Sub(warmAt).Seconds() / 1024
+	if peak < avg {
+		peak = avg
+	}
+	return avg, peak, nil
+}
 
-	fmt.Printf("\nSpeed test completed: %d/%d nodes tested\n", completedCount, totalNodes)
-	testLogger.Printf("Speed Test - Total nodes: %d, Successful: %d, Failed: %d", totalNodes, successCount, failedCount)
-	return testedNodes
+// downloadStream repeatedly downloads url into received until ctx expires.
+// It returns nil when stopped by the deadline and the error otherwise.
+func downloadStream(ctx context.Context, client *http.Client, url string, received *atomic.Int64) error {
+	buf := make([]byte, 32*1024)
+	for ctx.Err() == nil {
+		req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return statusError{resp.StatusCode}
+		}
+		for {
+			n, err := resp.Body.Read(buf)
+			received.Add(int64(n))
+			if err != nil {
+				break
+			}
+		}
+		resp.Body.Close()
+	}
+	return nil
 }
 
-func testNodes(cfg types.Config, nodes []types.Proxy, testChoice string) []types.Proxy {
+func testNodes(cfg types.Config, nodes []types.Proxy, stages []types.TestStage, progress progressFunc) []types.Proxy {
 	testLogFile, err := os.OpenFile("testNodeLog.txt", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
 	if err != nil {
 		log.Printf("Failed to create testNodeLog.txt: %v", err)
@@ -381,22 +277,43 @@ func testNodes(cfg types.Config, nodes []types.Proxy, testChoice string) []types
 	defer testLogFile.Close()
 	testLogger := log.New(testLogFile, "", 0)
 
-	switch testChoice {
-	case "0":
+	if len(stages) == 0 {
 		testLogger.Println("No tests selected, returning all nodes")
 		return nodes
-	case "1":
-		testLogger.Println("Running TCP test only")
-		return tcpTest(cfg, nodes, testLogger)
-	case "2":
-		testLogger.Println("Running Download speed test only")
-		return speedTest(cfg, nodes, testLogger)
-	case "3":
-		testLogger.Println("Running both TCP and Download speed tests")
-		tcpPassed := tcpTest(cfg, nodes, testLogger)
-		return speedTest(cfg, tcpPassed, testLogger)
-	default:
-		testLogger.Printf("Invalid choice '%s', defaulting to no test", testChoice)
-		return nodes
 	}
-}
\ No newline at end of file
+
+	fresh, cached, hits := splitCached(cfg, nodes, stages, testLogger)
+	defer func() {
+		summary := fmt.Sprintf("Run summary - %d nodes: %d tested, %d from cache", len(nodes), len(fresh), hits)
+		testLogger.Println(summary)
+		log.Println(summary)
+	}()
+	if len(fresh) == 0 {
+		return cached
+	}
+
+	xray, err := startXray(cfg)
+	if err != nil {
+		// Untested nodes must not reach the outputs as if they had passed
+		msg := fmt.Sprintf("Failed to start Xray (%s mode): %v; left out the %d nodes that needed testing", cfg.XrayMode, err, len(fresh))
+		testLogger.Println(msg)
+		log.Println(msg)
+		fresh = nil
+		return cached
+	}
+	defer func() {
+		if err := xray.Close(); err != nil {
+			testLogger.Printf("Failed to shut down Xray: %v", err)
+		}
+	}()
+	sched := newTestScheduler(cfg, xray, progress, testLogger)
+	defer func() {
+		testLogger.Printf("Failures by class across all tests: %s", formatFailures(sched.failureSummary()))
+	}()
+
+	tested := runPipeline(cfg, fresh, stages, sched, testLogger)
+	outcomes := sched.nodeOutcomes()
+	tested = updateHistory(cfg, fresh, tested, outcomes, testLogger)
+	storeResults(cfg, stages, fresh, tested, outcomes, testLogger)
+	return append(tested, cached...)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// throttledServer serves bodies of size bytes: burst bytes at once, then 16KB
+// every 10ms, about 1600KB/s per stream. It counts the requests it gets.
+func throttledServer(t *testing.T, size, burst int, requests *atomic.Int64) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests != nil {
+			requests.Add(1)
+		}
+		flusher := w.(http.Flusher)
+		chunk := make([]byte, 16*1024)
+		sent := 0
+		if burst > 0 {
+			w.Write(make([]byte, burst))
+			flusher.Flush()
+			sent = burst
+		}
+		for sent < size {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(10 * time.Millisecond):
+			}
+			n := min(len(chunk), size-sent)
+			if _, err := w.Write(chunk[:n]); err != nil {
+				return
+			}
+			flusher.Flush()
+			sent += n
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+const throttledRate = 1600.0 // KB/s per stream from throttledServer
+
+func inRange(got, want, tolerance float64) bool {
+	return got >= want*(1-tolerance) && got <= want*(1+tolerance)
+}
+
+func TestMeasureDownloadThrottled(t *testing.T) {
+	server := throttledServer(t, 16<<20, 0, nil)
+
+	avg, peak, err := measureDownload(server.Client(), server.URL, 1, time.Second, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !inRange(avg, throttledRate, 0.35) {
+		t.Errorf("avg = %.0fKB/s, want about %.0fKB/s", avg, throttledRate)
+	}
+	if peak < avg {
+		t.Errorf("peak %.0fKB/s is below avg %.0fKB/s", peak, avg)
+	}
+	if !inRange(peak, throttledRate, 0.6) {
+		t.Errorf("peak = %.0fKB/s, want about %.0fKB/s", peak, throttledRate)
+	}
+}
+
+func TestMeasureDownloadWarmup(t *testing.T) {
+	// A 4MB burst up front, as from a TCP slow start that overshoots
+	server := throttledServer(t, 16<<20, 4<<20, nil)
+
+	avg, peak, err := measureDownload(server.Client(), server.URL, 1, 1500*time.Millisecond, 500*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !inRange(avg, throttledRate, 0.35) {
+		t.Errorf("avg with warm-up = %.0fKB/s, want about %.0fKB/s without the burst", avg, throttledRate)
+	}
+	if peak > throttledRate*1.6 {
+		t.Errorf("peak with warm-up = %.0fKB/s, the burst was counted", peak)
+	}
+
+	avg, _, err = measureDownload(server.Client(), server.URL, 1, 1500*time.Millisecond, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if avg < throttledRate*2 {
+		t.Errorf("avg without warm-up = %.0fKB/s, want the burst to count", avg)
+	}
+}
+
+func TestMeasureDownloadStreams(t *testing.T) {
+	// Bodies of 256KB end several times per stream and are fetched again
+	var requests atomic.Int64
+	server := throttledServer(t, 256<<10, 0, &requests)
+
+	avg, _, err := measureDownload(server.Client(), server.URL, 3, time.Second, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !inRange(avg, 3*throttledRate, 0.35) {
+		t.Errorf("avg over 3 streams = %.0fKB/s, want about %.0fKB/s", avg, 3*throttledRate)
+	}
+	if n := requests.Load(); n < 6 {
+		t.Errorf("got %d requests, want each stream to fetch the body again", n)
+	}
+}
+
+func TestMeasureDownloadErrors(t *testing.T) {
+	t.Run("status", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}))
+		defer server.Close()
+
+		_, _, err := measureDownload(server.Client(), server.URL, 2, time.Second, 0)
+		var status statusError
+		if !errors.As(err, &status) || status.code != http.StatusServiceUnavailable {
+			t.Fatalf("err = %v, want a 503 status error", err)
+		}
+	})
+
+	t.Run("no data", func(t *testing.T) {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+		}))
+		defer server.Close()
+
+		_, _, err := measureDownload(server.Client(), server.URL, 1, 300*time.Millisecond, 0)
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("err = %v, want the deadline to be exceeded", err)
+		}
+	})
+}
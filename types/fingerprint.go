@@ -0,0 +1,62 @@
+package types
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"strings"
+)
+
+// Fingerprint returns a canonical identity for the node built from its protocol,
+// credentials, endpoint and transport. The display name is deliberately excluded
+// so the same node published under different names collapses to one entry.
+func (p Proxy) Fingerprint() string {
+	network := strings.ToLower(p.Network)
+	if network == "" {
+		network = "tcp"
+	}
+	wsHost := ""
+	wsPath := ""
+	for k, v := range p.WSOpts {
+		switch strings.ToLower(k) {
+		case "host":
+			wsHost = strings.ToLower(v)
+		case "path":
+			wsPath = v
+		}
+	}
+	if wsPath == "" {
+		wsPath = p.Path
+	}
+
+	fields := []string{
+		strings.ToLower(p.Type),
+		strings.ToLower(strings.TrimSuffix(strings.TrimSpace(p.Server), ".")),
+		strconv.Itoa(p.Port),
+		strings.ToLower(p.UUID),
+		p.Password,
+		strings.ToLower(p.Cipher),
+		strconv.Itoa(p.AlterID),
+		network,
+		wsHost,
+		wsPath,
+		strings.ToLower(p.SNI),
+		strconv.FormatBool(p.TLS),
+		p.Flow,
+		p.GrpcOpts["grpc-service-name"],
+		p.RealityOpts["public-key"],
+		p.RealityOpts["short-id"],
+		p.Obfs,
+		p.ObfsPassword,
+		p.ObfsParam,
+		p.Protocol,
+		p.ProtocolParam,
+	}
+	sum := sha256.Sum256([]byte(strings.Join(fields, "\x00")))
+	return hex.EncodeToString(sum[:8])
+}
+
+// Endpoint returns the lower-cased server:port of the node
+func (p Proxy) Endpoint() string {
+	return strings.ToLower(strings.TrimSuffix(strings.TrimSpace(p.Server), ".")) + ":" + strconv.Itoa(p.Port)
+}
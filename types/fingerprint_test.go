@@ -0,0 +1,87 @@
+package types
+
+import "testing"
+
+func TestFingerprint(t *testing.T) {
+	base := Proxy{
+		Name: "🇭🇰 HK 01", Type: "vless", Server: "hk.example.com", Port: 443,
+		UUID: "b831381d-6324-4d53-ad4f-8cda48b30811", Network: "ws", TLS: true, SNI: "hk.example.com",
+		WSOpts: map[string]string{"path": "/ws", "host": "cdn.example.com"},
+	}
+	with := func(change func(p *Proxy)) Proxy {
+		p := base
+		p.WSOpts = map[string]string{"path": "/ws", "host": "cdn.example.com"}
+		change(&p)
+		return p
+	}
+
+	same := map[string]Proxy{
+		"name":             with(func(p *Proxy) { p.Name = "香港 01 | 1.5x" }),
+		"server case":      with(func(p *Proxy) { p.Server = "HK.Example.COM" }),
+		"server dot":       with(func(p *Proxy) { p.Server = " hk.example.com. " }),
+		"uuid case":        with(func(p *Proxy) { p.UUID = "B831381D-6324-4D53-AD4F-8CDA48B30811" }),
+		"type case":        with(func(p *Proxy) { p.Type = "VLESS" }),
+		"network case":     with(func(p *Proxy) { p.Network = "WS" }),
+		"ws-opts key case": with(func(p *Proxy) { p.WSOpts = map[string]string{"Path": "/ws", "Host": "CDN.example.com"} }),
+		"path field":       with(func(p *Proxy) { p.WSOpts = map[string]string{"host": "cdn.example.com"}; p.Path = "/ws" }),
+		"sni case":         with(func(p *Proxy) { p.SNI = "HK.example.com" }),
+		"results":          with(func(p *Proxy) { p.Latency, p.Speed, p.EgressIP = 120, 900, "203.0.113.1" }),
+	}
+	for name, p := range same {
+		if p.Fingerprint() != base.Fingerprint() {
+			t.Errorf("%s: fingerprint changed, want the same node", name)
+		}
+	}
+
+	different := map[string]Proxy{
+		"path":         with(func(p *Proxy) { p.WSOpts["path"] = "/other" }),
+		"ws host":      with(func(p *Proxy) { p.WSOpts["host"] = "other.example.com" }),
+		"uuid":         with(func(p *Proxy) { p.UUID = "c831381d-6324-4d53-ad4f-8cda48b30811" }),
+		"port":         with(func(p *Proxy) { p.Port = 8443 }),
+		"type":         with(func(p *Proxy) { p.Type = "vmess" }),
+		"network":      with(func(p *Proxy) { p.Network = "grpc" }),
+		"tls":          with(func(p *Proxy) { p.TLS = false }),
+		"sni":          with(func(p *Proxy) { p.SNI = "other.example.com" }),
+		"flow":         with(func(p *Proxy) { p.Flow = "xtls-rprx-vision" }),
+		"reality":      with(func(p *Proxy) { p.RealityOpts = map[string]string{"public-key": "key", "short-id": "ab"} }),
+		"grpc service": with(func(p *Proxy) { p.GrpcOpts = map[string]string{"grpc-service-name": "svc"} }),
+	}
+	seen := map[string]string{base.Fingerprint(): "base"}
+	for name, p := range different {
+		fp := p.Fingerprint()
+		if other, ok := seen[fp]; ok {
+			t.Errorf("%s: same fingerprint as %s, want a different node", name, other)
+		}
+		seen[fp] = name
+	}
+
+	// Passwords are compared as given
+	ss := Proxy{Type: "ss", Server: "1.2.3.4", Port: 8388, Cipher: "aes-256-gcm", Password: "Secret"}
+	lower := ss
+	lower.Password = "secret"
+	if ss.Fingerprint() == lower.Fingerprint() {
+		t.Error("passwords differing in case share a fingerprint")
+	}
+	upperCipher := ss
+	upperCipher.Cipher = "AES-256-GCM"
+	if ss.Fingerprint() != upperCipher.Fingerprint() {
+		t.Error("cipher case changed the fingerprint")
+	}
+	tcp := ss
+	tcp.Network = "tcp"
+	if ss.Fingerprint() != tcp.Fingerprint() {
+		t.Error("an empty network differs from tcp")
+	}
+}
+
+func TestEndpoint(t *testing.T) {
+	tests := map[string]Proxy{
+		"hk.example.com:443": {Server: " HK.Example.com. ", Port: 443},
+		"1.2.3.4:8388":       {Server: "1.2.3.4", Port: 8388},
+	}
+	for want, p := range tests {
+		if got := p.Endpoint(); got != want {
+			t.Errorf("Endpoint(%q) = %q, want %q", p.Server, got, want)
+		}
+	}
+}
@@ -2,43 +2,203 @@ package types
 
 // Config holds the application configuration
 type Config struct {
-	SpeedTestURL    string   `yaml:"speed-test-url"`
-	Concurrent      int      `yaml:"concurrent"`
-	Timeout         int      `yaml:"timeout"`   // in milliseconds
-	MinSpeed        int      `yaml:"min-speed"` // in KB/s
-	SaveMethod      string   `yaml:"save-method"`
-	GistToken       string   `yaml:"github-token"`
-	GistID          string   `yaml:"github-gist-id"`
-	SubURLs         []string `yaml:"sub-urls"`
-	ProxyAddr       string   `yaml:"proxyAddr"`       // New field for SOCKS5 proxy address
-	ApiAddr         string   `yaml:"api-addr"`        // New field for API address
-	AllOutputFile   string   `yaml:"allOutputFile"`   // New field for all.yaml
-	UniqueNodesFile string   `yaml:"uniqueNodesFile"` // New field for uniqueNodes.txt
-	TCPTestURL      string   `yaml:"tcp-test-url"`
-	TCPTestMaxSpeed int      `yaml:"tcp-test-max-speed"`
+	SpeedTestURL      string             `yaml:"speed-test-url"`
+	SpeedTestDuration int                `yaml:"speed-test-duration"` // Download test time box in milliseconds
+	SpeedTestWarmup   int                `yaml:"speed-test-warmup"`   // Initial milliseconds excluded from the speed
+	SpeedTestStreams  int                `yaml:"speed-test-streams"`  // Parallel download streams per node
+	UploadTestURL     string             `yaml:"upload-test-url"`     // Endpoint accepting POSTed data, e.g. Cloudflare's __up
+	UploadSize        int                `yaml:"upload-size"`         // Generated upload size in KB
+	Concurrent        int                `yaml:"concurrent"`
+	RateLimit         float64            `yaml:"rate-limit"`       // Max node tests started per second, 0 for unlimited
+	PerHostLimit      int                `yaml:"per-host-limit"`   // Max concurrent tests against one server IP, 0 for unlimited
+	Timeout           int                `yaml:"timeout"`          // in milliseconds
+	MinSpeed          int                `yaml:"min-speed"`        // in KB/s
+	MinUploadSpeed    int                `yaml:"min-upload-speed"` // in KB/s
+	SaveMethod        string             `yaml:"save-method"`
+	GistToken         string             `yaml:"github-token"`
+	GistID            string             `yaml:"github-gist-id"`
+	SubURLs           []string           `yaml:"sub-urls"`
+	ProxyAddr         string             `yaml:"proxyAddr"`           // New field for SOCKS5 proxy address
+	ApiAddr           string             `yaml:"api-addr"`            // New field for API address
+	XrayMode          string             `yaml:"xray-mode"`           // embedded (default) or external
+	InboundPortRange  string             `yaml:"inbound-port-range"`  // Local ports for the per-worker test inbounds, e.g. 20000-20999
+	AllOutputFile     string             `yaml:"allOutputFile"`       // New field for all.yaml
+	UniqueNodesFile   string             `yaml:"uniqueNodesFile"`     // New field for uniqueNodes.txt
+	ProfileFile       string             `yaml:"profile-file"`        // Complete mihomo config written with the results, empty to disable
+	ProfileTemplate   string             `yaml:"profile-template"`    // text/template file for profile-file, empty for the built-in one
+	SingBoxFile       string             `yaml:"singbox-file"`        // sing-box JSON written with the results, empty to disable
+	SingBoxFull       bool               `yaml:"singbox-full-config"` // Write a complete config instead of just the outbounds array
+	SingBoxMultiplex  string             `yaml:"singbox-multiplex"`   // smux, yamux or h2mux to enable multiplexing, empty for none
+	TCPTestURL        string             `yaml:"tcp-test-url"`
+	TCPTestMaxSpeed   int                `yaml:"tcp-test-max-speed"`
+	DedupStrategy     string             `yaml:"dedup-strategy"`   // strict, endpoint or egress-ip
+	EgressIPCheck     bool               `yaml:"egress-ip-check"`  // Resolve each working node's exit IP after the TCP test
+	EgressEchoURL     string             `yaml:"egress-echo-url"`  // Endpoint that echoes the caller's IP
+	UDPTest           bool               `yaml:"udp-test"`         // Check UDP through each node after the TCP test
+	UDPTestMode       string             `yaml:"udp-test-mode"`    // dns or echo
+	UDPTestTarget     string             `yaml:"udp-test-target"`  // host:port of a DNS resolver or UDP echo server
+	UnlockChecks      []string           `yaml:"unlock-checks"`    // Services to check: netflix, disney, youtube, chatgpt, gemini
+	UnlockRequire     []string           `yaml:"unlock-require"`   // Drop nodes on which any of these is not unlocked
+	GeoIPCountryDB    string             `yaml:"geoip-country-db"` // Path to a GeoLite2-Country style mmdb
+	GeoIPASNDB        string             `yaml:"geoip-asn-db"`     // Path to a GeoLite2-ASN style mmdb
+	Retry             RetryConfig        `yaml:"retry"`
+	Tests             []TestStage        `yaml:"tests"`            // Ordered test pipeline; when empty the interactive menu is shown
+	HistoryDB         string             `yaml:"history-db"`       // bbolt file with every node's test results, empty to disable
+	HistoryWindow     int                `yaml:"history-window"`   // Days of results kept per node, 0 to keep everything
+	KeepProven        bool               `yaml:"keep-proven"`      // Keep nodes with a proven history when they fail a single run
+	ProvenUptime      float64            `yaml:"proven-uptime"`    // Minimum uptime percentage of a proven node
+	ProvenMinRuns     int                `yaml:"proven-min-runs"`  // Minimum recorded runs of a proven node
+	CacheTTL          int                `yaml:"cache-ttl"`        // Minutes a node's stored results are reused instead of retesting it, 0 to disable
+	SortBy            string             `yaml:"sort-by"`          // Output order, e.g. "-unlock.netflix, latency, -speed"
+	ScoreWeights      map[string]float64 `yaml:"score-weights"`    // Weights of fields in the score, each scaled to 0-1 across nodes
+	LatencyProbes     int                `yaml:"latency-probes"`   // Runs of each latency probe per node, 0 disables probing
+	LatencyURL        string             `yaml:"latency-url"`      // generate_204 style URL timed through the proxy
+	LatencyMax        map[string]float64 `yaml:"latency-max"`      // Upper bounds per metric, e.g. http-p95: 800
+	Filters           []FilterRule       `yaml:"filters"`          // Node filters applied in order after parsing or testing
+	RenameTemplate    string             `yaml:"rename-template"`  // text/template for output names, empty for the default
+	RenameRules       []RenameRule       `yaml:"rename-rules"`     // Regex replacements on subscription names before the template
+	RenameCollision   string             `yaml:"rename-collision"` // suffix or drop
+	Outputs           []OutputConfig     `yaml:"outputs"`          // Files written with the results; the single-file keys above when empty
 }
 
 // Proxy represents a parsed proxy configuration
 type Proxy struct {
-	Name           string            `yaml:"name"`
-	Server         string            `yaml:"server"`
-	Host           string            `yaml:"host"`
-	Port           int               `yaml:"port"`
-	Type           string            `yaml:"type"`
-	Cipher         string            `yaml:"cipher,omitempty"`
-	Password       string            `yaml:"password,omitempty"`
-	Network        string            `yaml:"network,omitempty"`
-	WSOpts         map[string]string `yaml:"ws-opts,omitempty"`
-	SkipCertVerify bool              `yaml:"skip-cert-verify,omitempty"`
-	TLS            bool              `yaml:"tls,omitempty"`
-	SNI            string            `yaml:"sni,omitempty"`
-	Path           string            `yaml:"path,omitempty"`
-	UUID           string            `yaml:"uuid,omitempty"`
-	AlterID        int               `yaml:"alterId"`
-	Obfs           string            `yaml:"obfs,omitempty"`
-	ObfsPassword   string            `yaml:"obfs-password,omitempty"`
-	Speed          float64
-	Latency        int64 // New field to store TCP test latency
+	Name              string            `yaml:"name"`
+	Server            string            `yaml:"server"`
+	Host              string            `yaml:"host"`
+	Port              int               `yaml:"port"`
+	Type              string            `yaml:"type"`
+	Cipher            string            `yaml:"cipher,omitempty"`
+	Password          string            `yaml:"password,omitempty"`
+	Network           string            `yaml:"network,omitempty"`
+	WSOpts            map[string]string `yaml:"ws-opts,omitempty"`
+	GrpcOpts          map[string]string `yaml:"grpc-opts,omitempty"`    // grpc-service-name
+	RealityOpts       map[string]string `yaml:"reality-opts,omitempty"` // public-key, short-id
+	SkipCertVerify    bool              `yaml:"skip-cert-verify,omitempty"`
+	TLS               bool              `yaml:"tls,omitempty"`
+	SNI               string            `yaml:"sni,omitempty"`
+	ALPN              []string          `yaml:"alpn,omitempty"`
+	ClientFingerprint string            `yaml:"client-fingerprint,omitempty"` // uTLS fingerprint such as chrome
+	Flow              string            `yaml:"flow,omitempty"`               // VLESS flow such as xtls-rprx-vision
+	Path              string            `yaml:"path,omitempty"`
+	UUID              string            `yaml:"uuid,omitempty"`
+	AlterID           int               `yaml:"alterId"`
+	Obfs              string            `yaml:"obfs,omitempty"`
+	ObfsPassword      string            `yaml:"obfs-password,omitempty"`
+	ObfsParam         string            `yaml:"obfs-param,omitempty"`     // SSR obfs parameter
+	Protocol          string            `yaml:"protocol,omitempty"`       // SSR protocol
+	ProtocolParam     string            `yaml:"protocol-param,omitempty"` // SSR protocol parameter
+	Speed             float64
+	Source            string            `yaml:"-"`             // Host of the subscription the node came from
+	PeakSpeed         float64           `yaml:"-"`             // Fastest sampling interval of the download test, KB/s
+	UploadSpeed       float64           `yaml:"-"`             // Upload test result, KB/s
+	UDP               *bool             `yaml:"udp,omitempty"` // UDP test result, nil when untested
+	UDPLatency        float64           `yaml:"-"`             // UDP round trip in milliseconds
+	Tags              map[string]string `yaml:"-"`             // Unlock verdicts keyed by service: unlocked, blocked or region-XX
+	ServerGeo         GeoInfo           `yaml:"-"`             // GeoIP of the server address
+	EgressGeo         GeoInfo           `yaml:"-"`             // GeoIP of the egress IP
+	Failure           *TestFailure      `yaml:"-"`             // Why the node last failed a test, nil if it never did
+	History           *NodeStats        `yaml:"-"`             // Summary of earlier runs from the history database
+	Score             float64           `yaml:"-"`             // Weighted score computed when saving
+	Latency           int64             // New field to store TCP test latency
+	EgressIP          string            `yaml:"-"` // Exit IP observed through the node
+	TCPPing           LatencyStats      `yaml:"-"` // Direct TCP connect to server:port
+	TLSPing           LatencyStats      `yaml:"-"` // Direct TLS handshake, TLS nodes only
+	HTTPPing          LatencyStats      `yaml:"-"` // Time to first byte of LatencyURL through the proxy
+}
+
+// TestStage is one step of the test pipeline. Unset parameters fall back to the
+// matching top-level settings.
+type TestStage struct {
+	Stage      string             `yaml:"stage"`       // tcp, latency, download, upload, udp, unlock or egress-ip
+	DropFailed *bool              `yaml:"drop-failed"` // Drop failed nodes instead of keeping them annotated
+	URL        string             `yaml:"url"`         // Test URL, or the host:port target for udp
+	Timeout    int                `yaml:"timeout"`     // in milliseconds
+	MaxLatency int                `yaml:"max-latency"` // tcp: in milliseconds
+	MinSpeed   int                `yaml:"min-speed"`   // download and upload: in KB/s
+	Probes     int                `yaml:"probes"`      // latency: runs of each probe
+	Max        map[string]float64 `yaml:"max"`         // latency: upper bounds per metric
+	Duration   int                `yaml:"duration"`    // download: in milliseconds
+	Warmup     int                `yaml:"warmup"`      // download: in milliseconds
+	Streams    int                `yaml:"streams"`     // download: parallel streams
+	Size       int                `yaml:"size"`        // upload: in KB
+	Mode       string             `yaml:"mode"`        // udp: dns or echo
+	Checks     []string           `yaml:"checks"`      // unlock: services to check
+	Require    []string           `yaml:"require"`     // unlock: services that must be unlocked
+}
+
+// FilterRule keeps or drops the nodes matching an expression such as
+// `type in [vless, trojan] && country != "CN" && latency < 800`
+type FilterRule struct {
+	Name   string `yaml:"name"`   // Label used in the log
+	Expr   string `yaml:"expr"`   // Filter expression
+	Action string `yaml:"action"` // include keeps matching nodes, exclude drops them
+	When   string `yaml:"when"`   // parse to filter before testing, test (default) to filter after
+}
+
+// OutputConfig enables one output file
+type OutputConfig struct {
+	Format  string            `yaml:"format"`  // uris, base64, clash, profile, singbox or report
+	Path    string            `yaml:"path"`    // File to write
+	Options map[string]string `yaml:"options"` // Format specific, e.g. template for profile
+}
+
+// RenameRule replaces every match of a regular expression in node names
+type RenameRule struct {
+	Match   string `yaml:"match"`
+	Replace string `yaml:"replace"` // May refer to groups as $1 or ${name}
+}
+
+// RetryConfig controls how failed test attempts are repeated
+type RetryConfig struct {
+	Attempts   int      `yaml:"attempts"`    // Tries per test including the first
+	Backoff    int      `yaml:"backoff"`     // Delay before the first retry in ms, doubled for each further retry
+	MaxBackoff int      `yaml:"max-backoff"` // Upper bound on the delay in ms, 0 for none
+	Jitter     float64  `yaml:"jitter"`      // Random spread of each delay as a fraction of it, e.g. 0.2
+	RetryOn    []string `yaml:"retry-on"`    // Failure classes worth retrying
+}
+
+// TestFailure records why a node failed a test stage
+type TestFailure struct {
+	Stage string `json:"stage"`
+	Class string `json:"class"` // dns, refused, tls, timeout, http-status, handshake, threshold or other
+	Error string `json:"error"`
+}
+
+// NodeStats summarises a node's recorded test history
+type NodeStats struct {
+	Runs          int     `json:"runs"`
+	Uptime        float64 `json:"uptime"`         // Percentage of runs passed
+	MedianLatency float64 `json:"median_latency"` // Milliseconds over passed runs
+	SpeedTrend    float64 `json:"speed_trend"`    // Change in download speed, KB/s per day
+	Stability     float64 `json:"stability"`      // 0-100, uptime penalised for flapping
+}
+
+// GeoInfo is what the offline GeoIP databases know about an IP
+type GeoInfo struct {
+	Country string // ISO 3166-1 alpha-2 code
+	ASN     uint
+	Org     string
+}
+
+// Geo returns the egress location when known, since that is where traffic
+// appears to come from, and the server location otherwise
+func (p Proxy) Geo() GeoInfo {
+	if p.EgressGeo.Country != "" || p.EgressGeo.ASN != 0 {
+		return p.EgressGeo
+	}
+	return p.ServerGeo
+}
+
+// LatencyStats summarises repeated runs of one latency probe. Times are in
+// milliseconds and Loss is the percentage of failed runs.
+type LatencyStats struct {
+	Runs   int     `json:"runs"` // 0 when the probe did not run
+	Min    float64 `json:"min"`
+	Median float64 `json:"median"`
+	P95    float64 `json:"p95"`
+	Jitter float64 `json:"jitter"`
+	Loss   float64 `json:"loss"`
 }
 
 // VMessConfig represents the JSON structure of a VMess proxy
@@ -74,4 +234,6 @@ type ProxyStats struct {
 	Hysteria2Fail    int
 	VLessSuccess     int
 	VLessFail        int
+	SSRSuccess       int
+	SSRFail          int
 }
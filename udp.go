@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"time"
+
+	"subs-check-custom/types"
+)
+
+const (
+	UDPTestDNS  = "dns"
+	UDPTestEcho = "echo"
+
+	defaultUDPTestTarget = "1.1.1.1:53"
+)
+
+// udpTest sends a DNS query or an echo payload to cfg.UDPTestTarget through every
+// node's SOCKS5 UDP ASSOCIATE and records whether UDP works
+func udpTest(cfg types.Config, nodes []types.Proxy, sched *testScheduler, testLogger *log.Logger, dropFailed bool) []types.Proxy {
+	target := cfg.UDPTestTarget
+	if target == "" {
+		target = defaultUDPTestTarget
+	}
+	mode := cfg.UDPTestMode
+	if mode == "" {
+		mode = UDPTestDNS
+	}
+	timeout := time.Duration(cfg.Timeout) * time.Millisecond
+
+	results, counts := sched.run("UDP test", nodes, func(nodeIndex int, n *types.Proxy, proxyAddr string) error {
+		var rtt time.Duration
+		attempts := 0
+		err := sched.retry.do(func(attempt int) error {
+			attempts = attempt
+			var err error
+			rtt, err = udpRoundTrip(proxyAddr, target, mode, timeout)
+			return err
+		})
+		ok := err == nil
+		n.UDP = &ok
+		if !ok {
+			testLogger.Printf("Node %d: UDP - No reply from %s after %d attempts for %s (%v)", nodeIndex, target, attempts, n.Name, err)
+			return err
+		}
+		n.UDPLatency = float64(rtt.Microseconds()) / 1000
+		testLogger.Printf("Node %d: UDP - %s replied in %.1f ms for %s", nodeIndex, target, n.UDPLatency, n.Name)
+		return nil
+	})
+
+	// hysteria2 carries UDP natively but has no Xray outbound, so its UDP support stays unknown
+	untested := 0
+	for _, n := range nodes {
+		if n.Type == "hysteria2" {
+			untested++
+		}
+	}
+	testLogger.Printf("UDP Test - Total nodes: %d, Successful: %d, Failed: %d, Skipped: %d (hysteria2, UDP unknown: %d), Failures: %s", counts.Total, counts.Passed, counts.Failed, counts.Skipped, untested, formatFailures(counts.Failures))
+	return keepResults(nodes, results, dropFailed)
+}
+
+// udpRoundTrip sends one probe to target through the SOCKS5 proxy at proxyAddr
+// and returns the time until a valid reply arrived
+func udpRoundTrip(proxyAddr, target, mode string, timeout time.Duration) (time.Duration, error) {
+	deadline := time.Now().Add(timeout)
+	ctrl, relay, err := socks5UDPAssociate(proxyAddr, timeout)
+	if err != nil {
+		return 0, err
+	}
+	// The association lives as long as the TCP control connection
+	defer ctrl.Close()
+
+	conn, err := net.DialUDP("udp", nil, relay)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(deadline)
+
+	var payload []byte
+	switch mode {
+	case UDPTestDNS:
+		payload = dnsQuery("www.google.com")
+	case UDPTestEcho:
+		payload = make([]byte, 32)
+		rand.Read(payload)
+	default:
+		return 0, fmt.Errorf("unknown udp-test-mode %q", mode)
+	}
+	header, err := socks5UDPHeader(target)
+	if err != nil {
+		return 0, err
+	}
+
+	start := time.Now()
+	if _, err := conn.Write(append(header, payload...)); err != nil {
+		return 0, err
+	}
+	buf := make([]byte, 2048)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return 0, err
+		}
+		reply, err := stripSOCKS5UDPHeader(buf[:n])
+		if err != nil {
+			continue
+		}
+		if mode == UDPTestDNS && isDNSReply(payload, reply) || mode == UDPTestEcho && bytes.Equal(payload, reply) {
+			return time.Since(start), nil
+		}
+	}
+}
+
+// socks5UDPAssociate opens a UDP association on the SOCKS5 proxy without
+// authentication and returns the control connection and the relay address
+func socks5UDPAssociate(proxyAddr string, timeout time.Duration) (net.Conn, *net.UDPAddr, error) {
+	ctrl, err := net.DialTimeout("tcp", proxyAddr, timeout)
+	if err != nil {
+		return nil, nil, err
+	}
+	ctrl.SetDeadline(time.Now().Add(timeout))
+	fail := func(err error) (net.Conn, *net.UDPAddr, error) {
+		ctrl.Close()
+		return nil, nil, err
+	}
+
+	if _, err := ctrl.Write([]byte{5, 1, 0}); err != nil {
+		return fail(err)
+	}
+	method := make([]byte, 2)
+	if _, err := io.ReadFull(ctrl, method); err != nil {
+		return fail(err)
+	}
+	if method[0] != 5 || method[1] != 0 {
+		return fail(errors.New("SOCKS5 proxy refused no-auth method"))
+	}
+
+	// Request UDP ASSOCIATE from an unspecified client address
+	if _, err := ctrl.Write([]byte{5, 3, 0, 1, 0, 0, 0, 0, 0, 0}); err != nil {
+		return fail(err)
+	}
+	reply := make([]byte, 4)
+	if _, err := io.ReadFull(ctrl, reply); err != nil {
+		return fail(err)
+	}
+	if reply[1] != 0 {
+		return fail(fmt.Errorf("UDP ASSOCIATE rejected with code %d", reply[1]))
+	}
+	host, port, err := readSOCKS5Addr(ctrl, reply[3])
+	if err != nil {
+		return fail(err)
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsUnspecified() {
+		// Relays bound to every interface are reached on the proxy's own host
+		proxyHost, _, _ := net.SplitHostPort(proxyAddr)
+		ip = net.ParseIP(proxyHost)
+	}
+	if ip == nil {
+		return fail(fmt.Errorf("unusable UDP relay address %s", host))
+	}
+	ctrl.SetDeadline(time.Time{})
+	return ctrl, &net.UDPAddr{IP: ip, Port: port}, nil
+}
+
+// readSOCKS5Addr reads a SOCKS5 address of the given type followed by its port
+func readSOCKS5Addr(r io.Reader, atyp byte) (string, int, error) {
+	var host string
+	switch atyp {
+	case 1, 4:
+		ip := make([]byte, map[byte]int{1: 4, 4: 16}[atyp])
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return "", 0, err
+		}
+		host = net.IP(ip).String()
+	case 3:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(r, length); err != nil {
+			return "", 0, err
+		}
+		name := make([]byte, length[0])
+		if _, err := io.ReadFull(r, name); err != nil {
+			return "", 0, err
+		}
+		host = string(name)
+	default:
+		return "", 0, fmt.Errorf("unknown SOCKS5 address type %d", atyp)
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(r, port); err != nil {
+		return "", 0, err
+	}
+	return host, int(binary.BigEndian.Uint16(port)), nil
+}
+
+// socks5UDPHeader builds the SOCKS5 UDP request header addressed to target
+func socks5UDPHeader(target string) ([]byte, error) {
+	host, portStr, err := net.SplitHostPort(target)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port in %s", target)
+	}
+	header := []byte{0, 0, 0}
+	if ip := net.ParseIP(host); ip == nil {
+		header = append(header, 3, byte(len(host)))
+		header = append(header, host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		header = append(header, 1)
+		header = append(header, ip4...)
+	} else {
+		header = append(header, 4)
+		header = append(header, ip.To16()...)
+	}
+	return binary.BigEndian.AppendUint16(header, uint16(port)), nil
+}
+
+// stripSOCKS5UDPHeader returns the payload of a SOCKS5 UDP datagram
+func stripSOCKS5UDPHeader(packet []byte) ([]byte, error) {
+	if len(packet) < 4 || packet[2] != 0 {
+		return nil, errors.New("short or fragmented SOCKS5 UDP packet")
+	}
+	r := bytes.NewReader(packet[4:])
+	if _, _, err := readSOCKS5Addr(r, packet[3]); err != nil {
+		return nil, err
+	}
+	return packet[len(packet)-r.Len():], nil
+}
+
+// dnsQuery builds a recursive DNS query for the A record of name
+func dnsQuery(name string) []byte {
+	query := make([]byte, 12, 64)
+	rand.Read(query[:2]) // Transaction ID
+	query[2] = 1         // Recursion desired
+	query[5] = 1         // One question
+	for _, label := range bytes.Split([]byte(name), []byte(".")) {
+		query = append(query, byte(len(label)))
+		query = append(query, label...)
+	}
+	return append(query, 0, 0, 1, 0, 1) // Root, type A, class IN
+}
+
+// isDNSReply reports whether reply answers query
+func isDNSReply(query, reply []byte) bool {
+	return len(reply) >= 12 && reply[0] == query[0] && reply[1] == query[1] && reply[2]&0x80 != 0
+}
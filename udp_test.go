@@ -0,0 +1,175 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// udpServer answers every datagram on 127.0.0.1 with reply(datagram), or not at
+// all when reply returns nil
+func udpServer(t *testing.T, reply func([]byte) []byte) string {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if out := reply(buf[:n]); out != nil {
+				conn.WriteTo(out, addr)
+			}
+		}
+	}()
+	return conn.LocalAddr().String()
+}
+
+// socks5UDPServer is a minimal SOCKS5 proxy that only supports UDP ASSOCIATE.
+// It reports its relay as 0.0.0.0, as proxies listening on every interface do.
+func socks5UDPServer(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			ctrl, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveUDPAssociate(ctrl)
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func serveUDPAssociate(ctrl net.Conn) {
+	defer ctrl.Close()
+	greeting := make([]byte, 3)
+	if _, err := io.ReadFull(ctrl, greeting); err != nil {
+		return
+	}
+	ctrl.Write([]byte{5, 0})
+	request := make([]byte, 10)
+	if _, err := io.ReadFull(ctrl, request); err != nil || request[1] != 3 {
+		return
+	}
+	relay, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		return
+	}
+	defer relay.Close()
+	ctrl.Write(binary.BigEndian.AppendUint16([]byte{5, 0, 0, 1, 0, 0, 0, 0}, uint16(relay.LocalAddr().(*net.UDPAddr).Port)))
+
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n, client, err := relay.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			packet := append([]byte(nil), buf[:n]...)
+			go relayDatagram(relay, client, packet)
+		}
+	}()
+	// The association ends when the client closes the control connection
+	io.Copy(io.Discard, ctrl)
+}
+
+func relayDatagram(relay *net.UDPConn, client *net.UDPAddr, packet []byte) {
+	payload, err := stripSOCKS5UDPHeader(packet)
+	if err != nil {
+		return
+	}
+	header := packet[:len(packet)-len(payload)]
+	host, port, err := readSOCKS5Addr(bytes.NewReader(header[4:]), header[3])
+	if err != nil {
+		return
+	}
+	upstream, err := net.Dial("udp", net.JoinHostPort(host, strconv.Itoa(port)))
+	if err != nil {
+		return
+	}
+	defer upstream.Close()
+	upstream.SetDeadline(time.Now().Add(time.Second))
+	upstream.Write(payload)
+	buf := make([]byte, 2048)
+	n, err := upstream.Read(buf)
+	if err != nil {
+		return
+	}
+	relay.WriteToUDP(append(append([]byte(nil), header...), buf[:n]...), client)
+}
+
+func TestUDPRoundTrip(t *testing.T) {
+	echo := udpServer(t, func(b []byte) []byte { return b })
+	resolver := udpServer(t, func(query []byte) []byte {
+		// Answer with the query's ID and the response bit set
+		reply := append([]byte(nil), query...)
+		reply[2] |= 0x80
+		return reply
+	})
+	garbled := udpServer(t, func(b []byte) []byte { return []byte("not what was sent") })
+	silent := udpServer(t, func([]byte) []byte { return nil })
+	proxy := socks5UDPServer(t)
+
+	tests := []struct {
+		name    string
+		target  string
+		mode    string
+		wantErr bool
+	}{
+		{name: "echo", target: echo, mode: UDPTestEcho},
+		{name: "dns", target: resolver, mode: UDPTestDNS},
+		{name: "dns from an echo server", target: echo, mode: UDPTestDNS, wantErr: true},
+		{name: "echo garbled", target: garbled, mode: UDPTestEcho, wantErr: true},
+		{name: "no reply", target: silent, mode: UDPTestEcho, wantErr: true},
+		{name: "unknown mode", target: echo, mode: "ping", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rtt, err := udpRoundTrip(proxy, tt.target, tt.mode, 500*time.Millisecond)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("udpRoundTrip() = %v, want an error", rtt)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rtt <= 0 {
+				t.Errorf("rtt = %v, want a positive round trip", rtt)
+			}
+		})
+	}
+}
+
+func TestSOCKS5UDPHeader(t *testing.T) {
+	for _, target := range []string{"1.1.1.1:53", "[2606:4700::1111]:53", "dns.example.com:5353"} {
+		header, err := socks5UDPHeader(target)
+		if err != nil {
+			t.Fatalf("%s: %v", target, err)
+		}
+		payload, err := stripSOCKS5UDPHeader(append(header, "payload"...))
+		if err != nil || string(payload) != "payload" {
+			t.Errorf("%s: stripped %q, %v", target, payload, err)
+		}
+		host, port, _ := readSOCKS5Addr(bytes.NewReader(header[4:]), header[3])
+		if got := net.JoinHostPort(host, strconv.Itoa(port)); got != target {
+			t.Errorf("header addresses %s, want %s", got, target)
+		}
+	}
+}
@@ -0,0 +1,290 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"subs-check-custom/types"
+)
+
+// Unlock tag values. A region-XX value means the service is available and
+// serves the catalogue of country XX.
+const (
+	UnlockUnlocked = "unlocked"
+	UnlockBlocked  = "blocked"
+	unlockRegion   = "region-"
+)
+
+// unlockChecker detects whether a service can be used through a node
+type unlockChecker interface {
+	// Name is the tag key the result is stored under
+	Name() string
+	// Check classifies the service as seen through client. Errors mean the check
+	// could not reach a verdict and are not recorded as blocked.
+	Check(client *http.Client) (string, error)
+}
+
+// unlockCheckers holds every available checker keyed by name. Base URLs are
+// fields so that checkers can be pointed at recorded responses.
+var unlockCheckers = map[string]unlockChecker{}
+
+func registerUnlockChecker(c unlockChecker) {
+	unlockCheckers[c.Name()] = c
+}
+
+func init() {
+	registerUnlockChecker(&netflixChecker{baseURL: "https://www.netflix.com"})
+	registerUnlockChecker(&disneyChecker{baseURL: "https://www.disneyplus.com"})
+	registerUnlockChecker(&youtubeChecker{baseURL: "https://www.youtube.com"})
+	registerUnlockChecker(&chatGPTChecker{apiURL: "https://api.openai.com", webURL: "https://chatgpt.com"})
+	registerUnlockChecker(&geminiChecker{baseURL: "https://gemini.google.com"})
+}
+
+// unlockTest runs the checkers named in cfg.UnlockChecks through every node and
+// stores their verdicts in the node's tags. Nodes missing any service in
+// cfg.UnlockRequire are dropped.
+func unlockTest(cfg types.Config, nodes []types.Proxy, sched *testScheduler, testLogger *log.Logger, dropFailed bool) []types.Proxy {
+	var checkers []unlockChecker
+	for _, name := range cfg.UnlockChecks {
+		c, ok := unlockCheckers[strings.ToLower(name)]
+		if !ok {
+			testLogger.Printf("Unlock - Ignoring unknown checker %s", name)
+			continue
+		}
+		checkers = append(checkers, c)
+	}
+	if len(checkers) == 0 {
+		return nodes
+	}
+	timeout := time.Duration(cfg.Timeout) * time.Millisecond
+
+	results, counts := sched.run("Unlock test", nodes, func(nodeIndex int, n *types.Proxy, proxyAddr string) error {
+		client, err := newProxyClient(proxyAddr, timeout)
+		if err != nil {
+			testLogger.Printf("Node %d: Fail - Failed to create SOCKS5 dialer for %s (%v)", nodeIndex, proxyAddr, err)
+			return err
+		}
+		if n.Tags == nil {
+			n.Tags = make(map[string]string)
+		}
+		for _, c := range checkers {
+			var verdict string
+			err := sched.retry.do(func(attempt int) error {
+				var err error
+				verdict, err = c.Check(client)
+				return err
+			})
+			if err != nil {
+				testLogger.Printf("Node %d: Unlock - %s check failed for %s (%v)", nodeIndex, c.Name(), n.Name, err)
+				continue
+			}
+			n.Tags[c.Name()] = verdict
+		}
+		testLogger.Printf("Node %d: Unlock - %s (%s)", nodeIndex, formatTags(n.Tags), n.Name)
+		for _, name := range cfg.UnlockRequire {
+			if !isUnlocked(n.Tags[strings.ToLower(name)]) {
+				testLogger.Printf("Node %d: Fail - %s is not unlocked for %s", nodeIndex, name, n.Name)
+				return fmt.Errorf("%w: %s is not unlocked", errThreshold, name)
+			}
+		}
+		return nil
+	})
+
+	testLogger.Printf("Unlock Test - Total nodes: %d, Successful: %d, Failed: %d, Skipped: %d, Failures: %s", counts.Total, counts.Passed, counts.Failed, counts.Skipped, formatFailures(counts.Failures))
+	return keepResults(nodes, results, dropFailed)
+}
+
+// isUnlocked reports whether a tag value means the service is available
+func isUnlocked(verdict string) bool {
+	return verdict == UnlockUnlocked || strings.HasPrefix(verdict, unlockRegion)
+}
+
+// regionVerdict returns region-XX for a two-letter code, or unlocked when the region is unknown
+func regionVerdict(code string) string {
+	if len(code) != 2 {
+		return UnlockUnlocked
+	}
+	return unlockRegion + strings.ToUpper(code)
+}
+
+// unlockLabel renders the available services for node names, e.g. netflix-US chatgpt
+func unlockLabel(tags map[string]string) string {
+	var labels []string
+	for name, verdict := range tags {
+		switch {
+		case verdict == UnlockUnlocked:
+			labels = append(labels, name)
+		case strings.HasPrefix(verdict, unlockRegion):
+			labels = append(labels, name+"-"+strings.TrimPrefix(verdict, unlockRegion))
+		}
+	}
+	sort.Strings(labels)
+	return strings.Join(labels, " ")
+}
+
+// formatTags renders all tags for the test log
+func formatTags(tags map[string]string) string {
+	if len(tags) == 0 {
+		return "no verdicts"
+	}
+	names := make([]string, 0, len(tags))
+	for name := range tags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	parts := make([]string, len(names))
+	for i, name := range names {
+		parts[i] = name + "=" + tags[name]
+	}
+	return strings.Join(parts, ", ")
+}
+
+// unlockGet fetches url with a desktop browser User-Agent and returns the
+// response with up to 1 MB of its body. Redirects are followed.
+func unlockGet(client *http.Client, url string) (*http.Response, string, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	req.Header.Set("User-Agent", "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/120.0.0.0 Safari/537.36")
+	req.Header.Set("Accept-Language", "en")
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, "", err
+	}
+	return resp, string(body), nil
+}
+
+var (
+	netflixRegionPath = regexp.MustCompile(`^/([a-z]{2})(?:-[a-z]{2})?/`)
+	countryCodeField  = regexp.MustCompile(`"countryCode"\s*:\s*"([A-Za-z]{2})"`)
+	disneyRegionField = regexp.MustCompile(`"region"\s*:\s*"([A-Za-z]{2})"`)
+	youtubeGLField    = regexp.MustCompile(`"GL"\s*:\s*"([A-Za-z]{2})"`)
+	traceLocField     = regexp.MustCompile(`(?m)^loc=([A-Z]{2})$`)
+)
+
+// netflixChecker requests a title that is only licensed in some regions. A 404
+// means only Netflix originals are available, which counts as blocked.
+type netflixChecker struct{ baseURL string }
+
+func (c *netflixChecker) Name() string { return "netflix" }
+
+func (c *netflixChecker) Check(client *http.Client) (string, error) {
+	resp, _, err := unlockGet(client, c.baseURL+"/title/70143836")
+	if err != nil {
+		return "", err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		// Netflix redirects to a regional path such as /jp/title/... outside the US
+		if m := netflixRegionPath.FindStringSubmatch(resp.Request.URL.Path); m != nil {
+			return regionVerdict(m[1]), nil
+		}
+		return regionVerdict("us"), nil
+	case http.StatusForbidden, http.StatusNotFound:
+		return UnlockBlocked, nil
+	}
+	return "", statusError{resp.StatusCode}
+}
+
+// disneyChecker loads the Disney+ home page, which redirects unsupported regions to /unavailable
+type disneyChecker struct{ baseURL string }
+
+func (c *disneyChecker) Name() string { return "disney" }
+
+func (c *disneyChecker) Check(client *http.Client) (string, error) {
+	resp, body, err := unlockGet(client, c.baseURL+"/")
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode == http.StatusForbidden || strings.Contains(resp.Request.URL.Path, "unavailable") {
+		return UnlockBlocked, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", statusError{resp.StatusCode}
+	}
+	if m := disneyRegionField.FindStringSubmatch(body); m != nil {
+		return regionVerdict(m[1]), nil
+	}
+	return UnlockUnlocked, nil
+}
+
+// youtubeChecker reads the YouTube Premium page, which states when Premium is unavailable
+type youtubeChecker struct{ baseURL string }
+
+func (c *youtubeChecker) Name() string { return "youtube" }
+
+func (c *youtubeChecker) Check(client *http.Client) (string, error) {
+	resp, body, err := unlockGet(client, c.baseURL+"/premium")
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", statusError{resp.StatusCode}
+	}
+	if strings.Contains(body, "Premium is not available in your country") {
+		return UnlockBlocked, nil
+	}
+	for _, field := range []*regexp.Regexp{countryCodeField, youtubeGLField} {
+		if m := field.FindStringSubmatch(body); m != nil {
+			return regionVerdict(m[1]), nil
+		}
+	}
+	return UnlockUnlocked, nil
+}
+
+// chatGPTChecker asks the OpenAI compliance endpoint whether the country is
+// supported and takes the region from the Cloudflare trace
+type chatGPTChecker struct{ apiURL, webURL string }
+
+func (c *chatGPTChecker) Name() string { return "chatgpt" }
+
+func (c *chatGPTChecker) Check(client *http.Client) (string, error) {
+	resp, body, err := unlockGet(client, c.apiURL+"/compliance/cookie_requirements")
+	if err != nil {
+		return "", err
+	}
+	if strings.Contains(body, "unsupported_country") || resp.StatusCode == http.StatusForbidden {
+		return UnlockBlocked, nil
+	}
+	resp, body, err = unlockGet(client, c.webURL+"/cdn-cgi/trace")
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode == http.StatusOK {
+		if m := traceLocField.FindStringSubmatch(body); m != nil {
+			return regionVerdict(m[1]), nil
+		}
+	}
+	return UnlockUnlocked, nil
+}
+
+// geminiChecker looks for the availability flag Gemini embeds in its landing page
+type geminiChecker struct{ baseURL string }
+
+func (c *geminiChecker) Name() string { return "gemini" }
+
+func (c *geminiChecker) Check(client *http.Client) (string, error) {
+	resp, body, err := unlockGet(client, c.baseURL+"/")
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", statusError{resp.StatusCode}
+	}
+	if strings.Contains(body, "45631641,null,true") {
+		return UnlockUnlocked, nil
+	}
+	return UnlockBlocked, nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// unlockRoute is a recorded response: a status and body, or a redirect
+type unlockRoute struct {
+	status   int
+	body     string
+	redirect string
+}
+
+func unlockServer(t *testing.T, routes map[string]unlockRoute) string {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route, ok := routes[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if route.redirect != "" {
+			http.Redirect(w, r, route.redirect, http.StatusFound)
+			return
+		}
+		w.WriteHeader(route.status)
+		io.WriteString(w, route.body)
+	}))
+	t.Cleanup(server.Close)
+	return server.URL
+}
+
+func TestUnlockCheckers(t *testing.T) {
+	netflix := func(url string) unlockChecker { return &netflixChecker{baseURL: url} }
+	disney := func(url string) unlockChecker { return &disneyChecker{baseURL: url} }
+	youtube := func(url string) unlockChecker { return &youtubeChecker{baseURL: url} }
+	gemini := func(url string) unlockChecker { return &geminiChecker{baseURL: url} }
+	chatgpt := func(url string) unlockChecker { return &chatGPTChecker{apiURL: url, webURL: url} }
+
+	tests := []struct {
+		name    string
+		checker func(url string) unlockChecker
+		routes  map[string]unlockRoute
+		want    string
+		wantErr bool
+	}{
+		{name: "netflix us", checker: netflix, routes: map[string]unlockRoute{
+			"/title/70143836": {status: http.StatusOK, body: "<title>Breaking Bad | Netflix</title>"},
+		}, want: "region-US"},
+		{name: "netflix jp", checker: netflix, routes: map[string]unlockRoute{
+			"/title/70143836":    {redirect: "/jp/title/70143836"},
+			"/jp/title/70143836": {status: http.StatusOK, body: "<title>ブレイキング・バッド | Netflix</title>"},
+		}, want: "region-JP"},
+		{name: "netflix originals only", checker: netflix, routes: map[string]unlockRoute{
+			"/title/70143836": {status: http.StatusNotFound},
+		}, want: UnlockBlocked},
+		{name: "netflix unexpected status", checker: netflix, routes: map[string]unlockRoute{
+			"/title/70143836": {status: http.StatusInternalServerError},
+		}, wantErr: true},
+
+		{name: "disney region", checker: disney, routes: map[string]unlockRoute{
+			"/": {status: http.StatusOK, body: `<script>window.__BAM__={"sdk":{"region":"sg","inSupportedLocation":true}}</script>`},
+		}, want: "region-SG"},
+		{name: "disney without region", checker: disney, routes: map[string]unlockRoute{
+			"/": {status: http.StatusOK, body: "<title>Disney+</title>"},
+		}, want: UnlockUnlocked},
+		{name: "disney unavailable", checker: disney, routes: map[string]unlockRoute{
+			"/":            {redirect: "/unavailable"},
+			"/unavailable": {status: http.StatusOK, body: "Disney+ is not available in your region."},
+		}, want: UnlockBlocked},
+		{name: "disney forbidden", checker: disney, routes: map[string]unlockRoute{
+			"/": {status: http.StatusForbidden},
+		}, want: UnlockBlocked},
+
+		{name: "youtube country code", checker: youtube, routes: map[string]unlockRoute{
+			"/premium": {status: http.StatusOK, body: `ytcfg.set({"INNERTUBE_CONTEXT":{"client":{"gl":"DE"}},"countryCode":"DE"});`},
+		}, want: "region-DE"},
+		{name: "youtube gl", checker: youtube, routes: map[string]unlockRoute{
+			"/premium": {status: http.StatusOK, body: `ytcfg.set({"GL":"kr","HL":"en"});`},
+		}, want: "region-KR"},
+		{name: "youtube no premium", checker: youtube, routes: map[string]unlockRoute{
+			"/premium": {status: http.StatusOK, body: `<div>YouTube Premium is not available in your country</div>"GL":"CN"`},
+		}, want: UnlockBlocked},
+		{name: "youtube unexpected status", checker: youtube, routes: map[string]unlockRoute{
+			"/premium": {status: http.StatusTooManyRequests},
+		}, wantErr: true},
+
+		{name: "gemini available", checker: gemini, routes: map[string]unlockRoute{
+			"/": {status: http.StatusOK, body: `AF_initDataCallback({data:[[45631641,null,true]]});`},
+		}, want: UnlockUnlocked},
+		{name: "gemini unavailable", checker: gemini, routes: map[string]unlockRoute{
+			"/": {status: http.StatusOK, body: `AF_initDataCallback({data:[[45631641,null,false]]});`},
+		}, want: UnlockBlocked},
+		{name: "gemini unexpected status", checker: gemini, routes: map[string]unlockRoute{
+			"/": {status: http.StatusBadGateway},
+		}, wantErr: true},
+
+		{name: "chatgpt region", checker: chatgpt, routes: map[string]unlockRoute{
+			"/compliance/cookie_requirements": {status: http.StatusOK, body: `{"cookie_requirements":[]}`},
+			"/cdn-cgi/trace":                  {status: http.StatusOK, body: "fl=123\nip=203.0.113.7\nloc=GB\ntls=TLSv1.3\n"},
+		}, want: "region-GB"},
+		{name: "chatgpt unsupported country", checker: chatgpt, routes: map[string]unlockRoute{
+			"/compliance/cookie_requirements": {status: http.StatusOK, body: `{"error":{"code":"unsupported_country"}}`},
+		}, want: UnlockBlocked},
+	}
+	client := &http.Client{Timeout: 5 * time.Second}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checker := tt.checker(unlockServer(t, tt.routes))
+			got, err := checker.Check(client)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Check() = %q, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("Check() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUnlockLabel(t *testing.T) {
+	tags := map[string]string{"netflix": "region-US", "disney": UnlockBlocked, "chatgpt": UnlockUnlocked, "youtube": "region-JP"}
+	if got, want := unlockLabel(tags), "chatgpt netflix-US youtube-JP"; got != want {
+		t.Errorf("unlockLabel() = %q, want %q", got, want)
+	}
+}
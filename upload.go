@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"subs-check-custom/types"
+)
+
+const defaultUploadTestURL = "https://speed.cloudflare.com/__up"
+
+// uploadTest POSTs cfg.UploadSize KB of generated data through every node and
+// keeps the nodes reaching cfg.MinUploadSpeed
+func uploadTest(cfg types.Config, nodes []types.Proxy, sched *testScheduler, testLogger *log.Logger, dropFailed bool) []types.Proxy {
+	uploadURL := cfg.UploadTestURL
+	if uploadURL == "" {
+		uploadURL = defaultUploadTestURL
+	}
+	size := int64(cfg.UploadSize) * 1024
+	if size <= 0 {
+		size = 10 * 1024 * 1024
+	}
+	timeout := time.Duration(cfg.Timeout) * time.Millisecond
+
+	results, counts := sched.run("Upload speed test", nodes, func(nodeIndex int, n *types.Proxy, proxyAddr string) error {
+		testLogger.Printf("Node %d: Running upload test (%d KB)", nodeIndex, size/1024)
+		client, err := newProxyClient(proxyAddr, 0)
+		if err != nil {
+			testLogger.Printf("Node %d: Fail - Failed to create SOCKS5 dialer (%v)", nodeIndex, err)
+			return err
+		}
+
+		var speed float64
+		err = sched.retry.do(func(attempt int) error {
+			if speed, err = measureUpload(client, uploadURL, size, timeout); err != nil {
+				testLogger.Printf("Node %d: Attempt %d failed - Upload test failed (%s: %v)", nodeIndex, attempt, classifyFailure(err), err)
+			}
+			return err
+		})
+		if err != nil {
+			testLogger.Printf("Node %d: Fail - Upload test failed (%v)", nodeIndex, err)
+			return err
+		}
+		n.UploadSpeed = speed
+		if speed < float64(cfg.MinUploadSpeed) {
+			testLogger.Printf("Node %d: Fail - Upload speed below minimum (%.1f KB/s)", nodeIndex, speed)
+			return fmt.Errorf("%w: %.1f KB/s < %d KB/s", errThreshold, speed, cfg.MinUploadSpeed)
+		}
+		testLogger.Printf("Node %d: Success - Upload test passed (%.1f KB/s)", nodeIndex, speed)
+		return nil
+	})
+
+	testLogger.Printf("Upload Test - Total nodes: %d, Successful: %d, Failed: %d, Skipped: %d, Failures: %s", counts.Total, counts.Passed, counts.Failed, counts.Skipped, formatFailures(counts.Failures))
+	return keepResults(nodes, results, dropFailed)
+}
+
+// measureUpload sends size bytes of random data to url and returns the upload
+// speed in KB/s. If the timeout expires first, the speed of the bytes sent so far is reported.
+func measureUpload(client *http.Client, url string, size int64, timeout time.Duration) (float64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// Random bytes keep compressing proxies from inflating the result
+	body := &countingReader{r: io.LimitReader(rand.New(rand.NewSource(time.Now().UnixNano())), size)}
+	req, err := http.NewRequestWithContext(ctx, "POST", url, body)
+	if err != nil {
+		return 0, err
+	}
+	req.ContentLength = size
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	elapsed := time.Since(start).Seconds()
+	sent := body.n.Load()
+	if err != nil {
+		if ctx.Err() == nil || sent == 0 {
+			return 0, err
+		}
+		return float64(sent) / elapsed / 1024, nil
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return 0, statusError{resp.StatusCode}
+	}
+	if elapsed == 0 {
+		return 0, fmt.Errorf("upload duration zero")
+	}
+	return float64(sent) / elapsed / 1024, nil
+}
+
+// countingReader counts the bytes read from r
+type countingReader struct {
+	r io.Reader
+	n atomic.Int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// sinkServer reads request bodies at about throttledRate, 16KB every 10ms,
+// counts the bytes and distinct byte values it gets and replies with status
+func sinkServer(t *testing.T, status int, received *atomic.Int64, distinct *atomic.Int64) *httptest.Server {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.Header.Get("Content-Type") != "application/octet-stream" {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		var seen [256]bool
+		chunk := make([]byte, 16*1024)
+		for {
+			n, err := io.ReadFull(r.Body, chunk)
+			received.Add(int64(n))
+			for _, b := range chunk[:n] {
+				if !seen[b] {
+					seen[b] = true
+					distinct.Add(1)
+				}
+			}
+			if err != nil {
+				break
+			}
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestMeasureUpload(t *testing.T) {
+	var received, distinct atomic.Int64
+	server := sinkServer(t, http.StatusOK, &received, &distinct)
+
+	const size = 1 << 20
+	speed, err := measureUpload(server.Client(), server.URL, size, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !inRange(speed, throttledRate, 0.35) {
+		t.Errorf("speed = %.0fKB/s, want about %.0fKB/s", speed, throttledRate)
+	}
+	if received.Load() != size {
+		t.Errorf("server received %d bytes, want %d", received.Load(), size)
+	}
+	if distinct.Load() < 250 {
+		t.Errorf("body used %d distinct byte values, want random data", distinct.Load())
+	}
+}
+
+func TestMeasureUploadTimeout(t *testing.T) {
+	var received, distinct atomic.Int64
+	server := sinkServer(t, http.StatusOK, &received, &distinct)
+	defer server.CloseClientConnections() // Stop reading what is left in the socket buffers
+
+	start := time.Now()
+	speed, err := measureUpload(server.Client(), server.URL, 64<<20, 500*time.Millisecond)
+	if err != nil {
+		t.Fatalf("err = %v, want the speed of the bytes sent before the timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("upload took %s, want it stopped at the timeout", elapsed)
+	}
+	// Socket buffers hold bytes the server has not read yet, so only the lower bound is tight
+	if speed < throttledRate*0.65 {
+		t.Errorf("speed = %.0fKB/s, want at least about %.0fKB/s", speed, throttledRate)
+	}
+	if received.Load() >= 64<<20 {
+		t.Error("the whole body was sent despite the timeout")
+	}
+}
+
+func TestMeasureUploadErrors(t *testing.T) {
+	t.Run("status", func(t *testing.T) {
+		var received, distinct atomic.Int64
+		server := sinkServer(t, http.StatusForbidden, &received, &distinct)
+
+		_, err := measureUpload(server.Client(), server.URL, 64<<10, time.Second)
+		var status statusError
+		if !errors.As(err, &status) || status.code != http.StatusForbidden {
+			t.Fatalf("err = %v, want a 403 status error", err)
+		}
+		if class := classifyFailure(err); class != "http-status" {
+			t.Errorf("classified as %s, want http-status", class)
+		}
+	})
+
+	t.Run("unreachable", func(t *testing.T) {
+		server := httptest.NewServer(http.NotFoundHandler())
+		url := server.URL
+		server.Close()
+
+		speed, err := measureUpload(http.DefaultClient, url, 64<<10, time.Second)
+		if err == nil || speed != 0 {
+			t.Fatalf("measureUpload = %v, %v; want an error and no speed", speed, err)
+		}
+	})
+}
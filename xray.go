@@ -0,0 +1,377 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"subs-check-custom/outbound"
+	"subs-check-custom/types"
+
+	"github.com/xtls/xray-core/app/proxyman/command"
+	routercmd "github.com/xtls/xray-core/app/router/command"
+	"github.com/xtls/xray-core/common"
+	"github.com/xtls/xray-core/common/serial"
+	"github.com/xtls/xray-core/core"
+	xoutbound "github.com/xtls/xray-core/features/outbound"
+	"github.com/xtls/xray-core/infra/conf"
+
+	// Register the Xray features, proxies and transports the embedded core needs
+	_ "github.com/xtls/xray-core/app/dispatcher"
+	_ "github.com/xtls/xray-core/app/log"
+	_ "github.com/xtls/xray-core/app/policy"
+	_ "github.com/xtls/xray-core/app/proxyman/inbound"
+	_ "github.com/xtls/xray-core/app/proxyman/outbound"
+	_ "github.com/xtls/xray-core/app/router"
+	_ "github.com/xtls/xray-core/proxy/blackhole"
+	_ "github.com/xtls/xray-core/proxy/shadowsocks"
+	_ "github.com/xtls/xray-core/proxy/socks"
+	_ "github.com/xtls/xray-core/proxy/trojan"
+	_ "github.com/xtls/xray-core/proxy/vless/outbound"
+	_ "github.com/xtls/xray-core/proxy/vmess/outbound"
+	_ "github.com/xtls/xray-core/transport/internet/grpc"
+	_ "github.com/xtls/xray-core/transport/internet/httpupgrade"
+	_ "github.com/xtls/xray-core/transport/internet/kcp"
+	_ "github.com/xtls/xray-core/transport/internet/reality"
+	_ "github.com/xtls/xray-core/transport/internet/splithttp"
+	_ "github.com/xtls/xray-core/transport/internet/tagged/taggedimpl"
+	_ "github.com/xtls/xray-core/transport/internet/tcp"
+	_ "github.com/xtls/xray-core/transport/internet/tls"
+	_ "github.com/xtls/xray-core/transport/internet/udp"
+	_ "github.com/xtls/xray-core/transport/internet/websocket"
+)
+
+// Xray modes selectable with xray-mode in config.yaml
+const (
+	XrayEmbedded = "embedded" // Run xray-core in-process with a generated config
+	XrayExternal = "external" // Drive an already running Xray through its gRPC API
+)
+
+const defaultInboundPortRange = "20000-20999"
+
+// xrayBackend is a running Xray with one isolated slot per concurrent test.
+// Each slot has its own SOCKS inbound, bound by a routing rule to its own
+// outbound tag, so traffic sent to ProxyAddr(slot) only leaves through the
+// node last installed with SwitchNode(slot, ...).
+type xrayBackend interface {
+	Slots() int
+	ProxyAddr(slot int) string
+	SwitchNode(slot int, node types.Proxy) error
+	Close() error
+}
+
+// xraySlot holds the inbound address and tags of one test slot
+type xraySlot struct {
+	addr        string
+	inboundTag  string
+	outboundTag string
+	ruleTag     string
+}
+
+// startXray returns the backend selected by cfg.XrayMode with cfg.Concurrent slots
+func startXray(cfg types.Config) (xrayBackend, error) {
+	slots, err := allocateSlots(cfg)
+	if err != nil {
+		return nil, err
+	}
+	switch cfg.XrayMode {
+	case XrayExternal:
+		return dialExternalXray(cfg.ApiAddr, slots)
+	case XrayEmbedded, "":
+		return startEmbeddedXray(slots)
+	default:
+		return nil, fmt.Errorf("unknown xray-mode '%s'", cfg.XrayMode)
+	}
+}
+
+// allocateSlots picks cfg.Concurrent free local ports from cfg.InboundPortRange.
+// Inbounds listen on the host part of cfg.ProxyAddr.
+func allocateSlots(cfg types.Config) ([]xraySlot, error) {
+	count := cfg.Concurrent
+	if count < 1 {
+		count = 1
+	}
+	host := "127.0.0.1"
+	if h, _, err := net.SplitHostPort(cfg.ProxyAddr); err == nil && h != "" {
+		host = h
+	}
+	portRange := cfg.InboundPortRange
+	if portRange == "" {
+		portRange = defaultInboundPortRange
+	}
+	first, last, err := parsePortRange(portRange)
+	if err != nil {
+		return nil, err
+	}
+
+	slots := make([]xraySlot, 0, count)
+	for port := first; port <= last && len(slots) < count; port++ {
+		addr := net.JoinHostPort(host, strconv.Itoa(port))
+		listener, err := net.Listen("tcp", addr)
+		if err != nil {
+			continue // Port already in use
+		}
+		listener.Close()
+		i := len(slots)
+		slots = append(slots, xraySlot{
+			addr:        addr,
+			inboundTag:  fmt.Sprintf("test_in_%d", i),
+			outboundTag: fmt.Sprintf("test_out_%d", i),
+			ruleTag:     fmt.Sprintf("test_rule_%d", i),
+		})
+	}
+	if len(slots) < count {
+		return nil, fmt.Errorf("only %d free ports in inbound-port-range %s, need %d", len(slots), portRange, count)
+	}
+	return slots, nil
+}
+
+// parsePortRange parses "first-last" or a single port
+func parsePortRange(portRange string) (int, int, error) {
+	firstStr, lastStr, found := strings.Cut(portRange, "-")
+	if !found {
+		lastStr = firstStr
+	}
+	first, err := strconv.Atoi(strings.TrimSpace(firstStr))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid inbound-port-range %s: %v", portRange, err)
+	}
+	last, err := strconv.Atoi(strings.TrimSpace(lastStr))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid inbound-port-range %s: %v", portRange, err)
+	}
+	if first < 1 || last > 65535 || first > last {
+		return 0, 0, fmt.Errorf("invalid inbound-port-range %s", portRange)
+	}
+	return first, last, nil
+}
+
+// inboundJSON is the SOCKS inbound of a slot in Xray JSON form
+func (s xraySlot) inboundJSON() map[string]interface{} {
+	host, portStr, _ := net.SplitHostPort(s.addr)
+	port, _ := strconv.Atoi(portStr)
+	return map[string]interface{}{
+		"tag":      s.inboundTag,
+		"listen":   host,
+		"port":     port,
+		"protocol": "socks",
+		"settings": map[string]interface{}{"udp": true},
+	}
+}
+
+// ruleJSON is the routing rule that binds a slot's inbound to its outbound
+func (s xraySlot) ruleJSON() map[string]interface{} {
+	return map[string]interface{}{
+		"type":        "field",
+		"ruleTag":     s.ruleTag,
+		"inboundTag":  []string{s.inboundTag},
+		"outboundTag": s.outboundTag,
+	}
+}
+
+// embeddedXray is an in-process xray-core instance generated with every slot's
+// inbound and routing rule. Node outbounds are swapped per slot at runtime.
+type embeddedXray struct {
+	instance *core.Instance
+	manager  xoutbound.Manager
+	slots    []xraySlot
+}
+
+func startEmbeddedXray(slots []xraySlot) (*embeddedXray, error) {
+	inbounds := make([]interface{}, 0, len(slots))
+	rules := make([]interface{}, 0, len(slots))
+	for _, slot := range slots {
+		inbounds = append(inbounds, slot.inboundJSON())
+		rules = append(rules, slot.ruleJSON())
+	}
+	configJSON, err := json.Marshal(map[string]interface{}{
+		"log":      map[string]interface{}{"loglevel": "none"},
+		"inbounds": inbounds,
+		// A blackhole default keeps traffic from a slot without a node from leaking out directly
+		"outbounds": []interface{}{map[string]interface{}{"tag": "block", "protocol": "blackhole"}},
+		"routing":   map[string]interface{}{"rules": rules},
+	})
+	if err != nil {
+		return nil, err
+	}
+	jsonConfig := &conf.Config{}
+	if err := json.Unmarshal(configJSON, jsonConfig); err != nil {
+		return nil, err
+	}
+	coreConfig, err := jsonConfig.Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build Xray config: %v", err)
+	}
+
+	instance, err := core.New(coreConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Xray instance: %v", err)
+	}
+	if err := instance.Start(); err != nil {
+		instance.Close()
+		return nil, fmt.Errorf("failed to start Xray instance: %v", err)
+	}
+	return &embeddedXray{
+		instance: instance,
+		manager:  instance.GetFeature(xoutbound.ManagerType()).(xoutbound.Manager),
+		slots:    slots,
+	}, nil
+}
+
+func (x *embeddedXray) Slots() int { return len(x.slots) }
+
+func (x *embeddedXray) ProxyAddr(slot int) string { return x.slots[slot].addr }
+
+func (x *embeddedXray) SwitchNode(slot int, node types.Proxy) error {
+	tag := x.slots[slot].outboundTag
+	handler, err := outbound.Build(node, tag)
+	if err != nil {
+		return err
+	}
+	x.removeOutbound(tag)
+	if err := core.AddOutboundHandler(x.instance, handler); err != nil {
+		return fmt.Errorf("failed to add outbound: %v", err)
+	}
+	return nil
+}
+
+// removeOutbound drops and closes the outbound with the given tag, if any
+func (x *embeddedXray) removeOutbound(tag string) {
+	if handler := x.manager.GetHandler(tag); handler != nil {
+		x.manager.RemoveHandler(context.Background(), tag)
+		common.Close(handler)
+	}
+}
+
+func (x *embeddedXray) Close() error {
+	for _, slot := range x.slots {
+		x.removeOutbound(slot.outboundTag)
+	}
+	return x.instance.Close()
+}
+
+// externalXray drives an Xray started outside this program through its gRPC API.
+// That Xray must enable HandlerService and RoutingService.
+type externalXray struct {
+	conn    *grpc.ClientConn
+	handler command.HandlerServiceClient
+	router  routercmd.RoutingServiceClient
+	slots   []xraySlot
+}
+
+func dialExternalXray(apiAddr string, slots []xraySlot) (*externalXray, error) {
+	conn, err := grpc.Dial(apiAddr, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to Xray API at %s: %v", apiAddr, err)
+	}
+	x := &externalXray{
+		conn:    conn,
+		handler: command.NewHandlerServiceClient(conn),
+		router:  routercmd.NewRoutingServiceClient(conn),
+		slots:   slots,
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, slot := range slots {
+		if err := x.addSlot(ctx, slot); err != nil {
+			x.Close()
+			return nil, err
+		}
+	}
+	return x, nil
+}
+
+// addSlot installs a slot's inbound and routing rule, replacing leftovers from an earlier run
+func (x *externalXray) addSlot(ctx context.Context, slot xraySlot) error {
+	inboundJSON, err := json.Marshal(slot.inboundJSON())
+	if err != nil {
+		return err
+	}
+	inboundConfig := &conf.InboundDetourConfig{}
+	if err := json.Unmarshal(inboundJSON, inboundConfig); err != nil {
+		return err
+	}
+	inbound, err := inboundConfig.Build()
+	if err != nil {
+		return fmt.Errorf("failed to build inbound %s: %v", slot.inboundTag, err)
+	}
+	_, _ = x.handler.RemoveInbound(ctx, &command.RemoveInboundRequest{Tag: slot.inboundTag})
+	if _, err := x.handler.AddInbound(ctx, &command.AddInboundRequest{Inbound: inbound}); err != nil {
+		return fmt.Errorf("failed to add inbound %s: %v", slot.inboundTag, err)
+	}
+
+	ruleJSON, err := json.Marshal(map[string]interface{}{"rules": []interface{}{slot.ruleJSON()}})
+	if err != nil {
+		return err
+	}
+	routerConfig := &conf.RouterConfig{}
+	if err := json.Unmarshal(ruleJSON, routerConfig); err != nil {
+		return err
+	}
+	rule, err := routerConfig.Build()
+	if err != nil {
+		return fmt.Errorf("failed to build routing rule %s: %v", slot.ruleTag, err)
+	}
+	_, _ = x.router.RemoveRule(ctx, &routercmd.RemoveRuleRequest{RuleTag: slot.ruleTag})
+	if _, err := x.router.AddRule(ctx, &routercmd.AddRuleRequest{Config: serial.ToTypedMessage(rule), ShouldAppend: true}); err != nil {
+		return fmt.Errorf("failed to add routing rule %s: %v", slot.ruleTag, err)
+	}
+	return nil
+}
+
+func (x *externalXray) Slots() int { return len(x.slots) }
+
+func (x *externalXray) ProxyAddr(slot int) string { return x.slots[slot].addr }
+
+func (x *externalXray) SwitchNode(slot int, node types.Proxy) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tag := x.slots[slot].outboundTag
+	handler, err := outbound.Build(node, tag)
+	if err != nil {
+		return err
+	}
+
+	// Remove existing outbound if it exists
+	_, _ = x.handler.RemoveOutbound(ctx, &command.RemoveOutboundRequest{Tag: tag})
+	// Ignore error if outbound doesn't exist
+
+	// Add the new outbound
+	_, err = x.handler.AddOutbound(ctx, &command.AddOutboundRequest{Outbound: handler})
+	if err != nil {
+		return fmt.Errorf("failed to add outbound: %v", err)
+	}
+	return nil
+}
+
+// Close removes every slot from the external Xray and closes the API connection
+func (x *externalXray) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, slot := range x.slots {
+		_, _ = x.router.RemoveRule(ctx, &routercmd.RemoveRuleRequest{RuleTag: slot.ruleTag})
+		_, _ = x.handler.RemoveInbound(ctx, &command.RemoveInboundRequest{Tag: slot.inboundTag})
+		_, _ = x.handler.RemoveOutbound(ctx, &command.RemoveOutboundRequest{Tag: slot.outboundTag})
+	}
+	return x.conn.Close()
+}
+
+// slotPool hands out free Xray slot indexes to concurrent tests
+type slotPool chan int
+
+func newSlotPool(xray xrayBackend) slotPool {
+	pool := make(slotPool, xray.Slots())
+	for slot := 0; slot < xray.Slots(); slot++ {
+		pool <- slot
+	}
+	return pool
+}